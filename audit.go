@@ -0,0 +1,102 @@
+package supersimple
+
+import (
+	"context"
+	"log"
+	"reflect"
+
+	supersimple "github.com/allen-woods/supersimple/models"
+	"go.mongodb.org/mongo-driver/bson"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// audit records a write made by the actor of ctx, with whether its name
+// was verified. before and after are the target as it was and as it is
+// now, either of which may be nil. The write has already happened, so a
+// failure to record it is logged rather than returned.
+//
+// The entry is recorded even if the request is cancelled or times out
+// meanwhile, since the write it describes is not undone.
+func (r *Resolver) audit(ctx context.Context, op string, target *primitive.ObjectID, before, after interface{}) {
+	if r.Audit == nil {
+		return
+	}
+
+	actor := ActorFromContext(ctx)
+	e := &supersimple.AuditEntry{
+		Operation:     op,
+		Actor:         actor.Name,
+		ActorRole:     string(actor.Role),
+		ActorVerified: actor.Verified,
+		TargetID:      target,
+		Before:        snapshot(before),
+		After:         snapshot(after),
+		Timestamp:     now(),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultOpTimeout)
+	defer cancel()
+	if err := r.Audit.Record(ctx, e); err != nil {
+		log.Printf("audit of %s failed: %v", op, err)
+	}
+}
+
+// snapshot renders v as extended JSON, the way it is stored in Mongo.
+func snapshot(v interface{}) *string {
+	if v == nil {
+		return nil
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil
+	}
+
+	b, err := bson.MarshalExtJSON(v, false, false)
+	if err != nil {
+		log.Println("audit snapshot failed:", err)
+		return nil
+	}
+	s := string(b)
+	return &s
+}
+
+// userBefore returns the stored user for the before snapshot of a write,
+// or nil if auditing is off or there is no such user.
+func (r *Resolver) userBefore(ctx context.Context, id primitive.ObjectID) *supersimple.User {
+	users := r.usersBefore(ctx, supersimple.UserFilter{Ids: []primitive.ObjectID{id}})
+	return users[id]
+}
+
+// usersBefore returns the users matching filter, deleted or not, for the
+// before snapshots of a bulk write.
+func (r *Resolver) usersBefore(ctx context.Context, filter supersimple.UserFilter) map[primitive.ObjectID]*supersimple.User {
+	if r.Audit == nil {
+		return nil
+	}
+
+	filter.IncludeDeleted = true
+	users, err := r.UserStore.Find(ctx, filter, nil)
+	if err != nil {
+		return nil
+	}
+
+	byID := make(map[primitive.ObjectID]*supersimple.User, len(users))
+	for _, u := range users {
+		byID[u.ID] = u
+	}
+	return byID
+}
+
+func (r *Resolver) authorBefore(ctx context.Context, id primitive.ObjectID) *supersimple.Author {
+	if r.Audit == nil {
+		return nil
+	}
+	a, _ := r.LibraryStore.FindAuthor(ctx, id)
+	return a
+}
+
+func (r *Resolver) bookBefore(ctx context.Context, id primitive.ObjectID) *supersimple.Book {
+	if r.Audit == nil {
+		return nil
+	}
+	b, _ := r.LibraryStore.FindBook(ctx, id)
+	return b
+}
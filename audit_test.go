@@ -0,0 +1,40 @@
+package supersimple
+
+import (
+	"context"
+	"testing"
+
+	supersimple "github.com/allen-woods/supersimple/models"
+)
+
+// ctxAuditStore fails every Record made with a context that is done.
+type ctxAuditStore struct {
+	*MemoryAuditStore
+}
+
+func (s ctxAuditStore) Record(ctx context.Context, e *supersimple.AuditEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.MemoryAuditStore.Record(ctx, e)
+}
+
+func TestAuditOutlivesRequest(t *testing.T) {
+	store := ctxAuditStore{NewMemoryAuditStore()}
+	r := &Resolver{Audit: store}
+
+	ctx, cancel := context.WithCancel(WithActor(context.Background(), Actor{Name: "root", Role: RoleAdmin, Verified: true}))
+	cancel()
+	r.audit(ctx, "deleteAllUsers", nil, nil, nil)
+
+	entries, _, err := store.Page(context.Background(), nil, nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("recorded %d entries after the request was cancelled, want 1", len(entries))
+	}
+	if e := entries[0]; e.Actor != "root" || e.ActorRole != string(RoleAdmin) || !e.ActorVerified {
+		t.Errorf("recorded actor %q, role %q, verified %v", e.Actor, e.ActorRole, e.ActorVerified)
+	}
+}
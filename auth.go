@@ -0,0 +1,80 @@
+package supersimple
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Role is what an actor is allowed to do.
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin"
+)
+
+// Actor is whoever issued the current request. Verified is set when the
+// name comes from a bearer token rather than from the X-Actor header,
+// which anyone may send.
+type Actor struct {
+	Name     string
+	Role     Role
+	Verified bool
+}
+
+// Identity is an actor that authenticates with a bearer token.
+type Identity struct {
+	Token string
+	Actor Actor
+}
+
+var anonymous = Actor{Name: "anonymous", Role: RoleUser}
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx that carries a.
+func WithActor(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, a)
+}
+
+// ActorFromContext returns the actor of the current request, or an
+// anonymous user if there is none.
+func ActorFromContext(ctx context.Context) Actor {
+	if a, ok := ctx.Value(actorKey{}).(Actor); ok {
+		return a
+	}
+	return anonymous
+}
+
+// AuthMiddleware identifies the actor of every request. A request bearing
+// the token of one of identities acts as that identity, and its name is
+// verified. Everyone else is an unverified regular user, named by the
+// X-Actor header if it is sent.
+func AuthMiddleware(identities []Identity, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a := anonymous
+		if name := r.Header.Get("X-Actor"); name != "" {
+			a.Name = name
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		for _, id := range identities {
+			if token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(id.Token)) == 1 {
+				a = id.Actor
+				a.Verified = true
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithActor(r.Context(), a)))
+	})
+}
+
+// requireAdmin fails unless the current actor is an admin.
+func requireAdmin(ctx context.Context) error {
+	if ActorFromContext(ctx).Role != RoleAdmin {
+		return errorf(CodeForbidden, "this operation is restricted to admins")
+	}
+	return nil
+}
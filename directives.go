@@ -0,0 +1,156 @@
+package supersimple
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/99designs/gqlgen/graphql"
+)
+
+// Directives returns the implementations of the validation directives
+// declared in schema.graphql, for Config.Directives.
+func Directives() DirectiveRoot {
+	return DirectiveRoot{
+		Length:  lengthDirective,
+		Pattern: patternDirective,
+	}
+}
+
+func lengthDirective(ctx context.Context, obj interface{}, next graphql.Resolver, min *int, max int) (interface{}, error) {
+	v, err := next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := stringValue(v)
+	if !ok {
+		return v, nil
+	}
+
+	n := utf8.RuneCountInString(s)
+	if min != nil && n < *min {
+		if *min == 1 {
+			return nil, invalidInput(ctx, obj, s, "must not be empty")
+		}
+		return nil, invalidInput(ctx, obj, s, "must be at least %d characters long", *min)
+	}
+	if n > max {
+		return nil, invalidInput(ctx, obj, s, "must be at most %d characters long", max)
+	}
+	return v, nil
+}
+
+// patterns caches the compiled regex of every @pattern in the schema.
+var patterns sync.Map
+
+func patternDirective(ctx context.Context, obj interface{}, next graphql.Resolver, regex string) (interface{}, error) {
+	v, err := next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := stringValue(v)
+	if !ok {
+		return v, nil
+	}
+
+	re, ok := patterns.Load(regex)
+	if !ok {
+		// The pattern must match the whole value, not just part of it.
+		compiled, err := regexp.Compile("^(?:" + regex + ")$")
+		if err != nil {
+			return nil, internalError(fmt.Errorf("@pattern(regex: %q): %v", regex, err))
+		}
+		re, _ = patterns.LoadOrStore(regex, compiled)
+	}
+	if !re.(*regexp.Regexp).MatchString(s) {
+		return nil, invalidInput(ctx, obj, s, "must match %s", regex)
+	}
+	return v, nil
+}
+
+// stringValue unwraps the value of a String or nullable String input.
+// Null values are left for the schema to accept or reject.
+func stringValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case *string:
+		if v != nil {
+			return *v, true
+		}
+	}
+	return "", false
+}
+
+// invalidInput reports that the input value s, found in obj, is invalid.
+func invalidInput(ctx context.Context, obj interface{}, s string, format string, args ...interface{}) *Error {
+	e := errorf(CodeValidation, format, args...)
+	e.Field = inputPath(ctx, obj, s)
+	if len(e.Field) > 0 {
+		e.Message = fmt.Sprintf("%v %s", e.Field[len(e.Field)-1], e.Message)
+	} else {
+		e.Message = "value " + e.Message
+	}
+	return e
+}
+
+// inputPath locates the input value s within the arguments of the field
+// being resolved. A directive is only given the raw object that holds the
+// value, either the field's arguments or an input object nested somewhere
+// inside them, so the path is recovered by searching the arguments for
+// that object and the object for the value. It returns nil if the value
+// cannot be found.
+func inputPath(ctx context.Context, obj interface{}, s string) []interface{} {
+	holder, ok := obj.(map[string]interface{})
+	rctx := graphql.GetResolverContext(ctx)
+	if !ok || rctx == nil {
+		return nil
+	}
+
+	args := rctx.Field.ArgumentMap(graphql.GetRequestContext(ctx).Variables)
+	path, ok := findInput(args, holder)
+	if !ok {
+		return nil
+	}
+	for _, k := range sortedKeys(holder) {
+		if v, ok := holder[k].(string); ok && v == s {
+			return append(path, k)
+		}
+	}
+	return nil
+}
+
+// findInput returns the path from node to the first object equal to target.
+func findInput(node interface{}, target map[string]interface{}) ([]interface{}, bool) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if reflect.DeepEqual(n, target) {
+			return []interface{}{}, true
+		}
+		for _, k := range sortedKeys(n) {
+			if p, ok := findInput(n[k], target); ok {
+				return append([]interface{}{k}, p...), true
+			}
+		}
+	case []interface{}:
+		for i, v := range n {
+			if p, ok := findInput(v, target); ok {
+				return append([]interface{}{i}, p...), true
+			}
+		}
+	}
+	return nil, false
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
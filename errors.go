@@ -0,0 +1,150 @@
+package supersimple
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/gqlerror"
+)
+
+// Code classifies an error for clients. It is exposed as extensions.code
+// and must never change once published.
+type Code string
+
+const (
+	CodeNotFound   Code = "NOT_FOUND"
+	CodeValidation Code = "VALIDATION"
+	CodeConflict   Code = "CONFLICT"
+	CodeForbidden  Code = "FORBIDDEN"
+	CodeInternal   Code = "INTERNAL"
+)
+
+// Error is an error whose message is safe to show to clients.
+type Error struct {
+	Code    Code
+	Message string
+	Err     error
+	// CorrelationID ties a masked internal error to its server log entry.
+	CorrelationID string
+	// Field is the path to the invalid input within the field's
+	// arguments, such as ["input", 2, "name"], for validation errors.
+	Field []interface{}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Extensions implements graphql.ExtendedError.
+func (e *Error) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": e.Code}
+	if e.CorrelationID != "" {
+		ext["correlationId"] = e.CorrelationID
+	}
+	if e.Field != nil {
+		ext["field"] = e.Field
+	}
+	return ext
+}
+
+func errorf(code Code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func internalError(err error) *Error {
+	return &Error{Code: CodeInternal, Message: "internal error", Err: err}
+}
+
+// storeError maps a store error for the named kind of document onto
+// a client error.
+func storeError(kind string, err error) error {
+	switch err {
+	case ErrNotFound:
+		return errorf(CodeNotFound, "%s not found", kind)
+	case ErrVersionConflict:
+		return errorf(CodeConflict, "%s has been modified since it was read", kind)
+	case ErrDuplicate:
+		return errorf(CodeConflict, "%s has the same name as an existing user", kind)
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return internalError(err)
+}
+
+func newCorrelationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// clientError converts any error into the *Error shown to clients. The
+// details of internal errors are logged under a correlation ID instead of
+// being sent to the client.
+func clientError(err error) *Error {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = internalError(err)
+	}
+	if e.Code == CodeInternal && e.CorrelationID == "" {
+		e.CorrelationID = newCorrelationID()
+		log.Printf("Error [%s]: %v", e.CorrelationID, e)
+	}
+	return e
+}
+
+// ErrorPresenter renders resolver errors with a stable extensions.code.
+// Errors that are not an *Error are reported as INTERNAL.
+func ErrorPresenter(ctx context.Context, err error) *gqlerror.Error {
+	if _, ok := err.(*gqlerror.Error); ok {
+		return graphql.DefaultErrorPresenter(ctx, err)
+	}
+
+	e := clientError(err)
+	gqlerr := graphql.DefaultErrorPresenter(ctx, e)
+	gqlerr.Message = e.Message
+	return gqlerr
+}
+
+// Recover turns a panic inside a resolver into a masked internal error,
+// logging the panic and its stack under the error's correlation ID.
+func Recover(ctx context.Context, p interface{}) error {
+	e := &Error{Code: CodeInternal, Message: "internal error", CorrelationID: newCorrelationID()}
+	log.Printf("panic [%s]: %v\n%s", e.CorrelationID, p, debug.Stack())
+	return e
+}
+
+// RecoverMiddleware catches panics that escape the GraphQL handler itself
+// and answers with the same masked internal error the resolvers produce.
+func RecoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if p := recover(); p != nil {
+				e := Recover(r.Context(), p).(*Error)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"errors": []*gqlerror.Error{{Message: e.Message, Extensions: e.Extensions()}},
+					"data":   nil,
+				})
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
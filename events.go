@@ -0,0 +1,124 @@
+package supersimple
+
+import (
+	"context"
+	"sync"
+
+	supersimple "github.com/allen-woods/supersimple/models"
+)
+
+// UserEventKind says what happened to a user.
+type UserEventKind int
+
+const (
+	UserCreated UserEventKind = iota
+	UserUpdated
+	UserDeleted
+)
+
+// UserEvent is a single change to the users collection.
+type UserEvent struct {
+	Kind UserEventKind
+	User *supersimple.User
+}
+
+// subscriberBuffer is how many events a slow subscriber may fall behind
+// before further events are dropped for it.
+const subscriberBuffer = 16
+
+// UserEvents fans user changes out to GraphQL subscriptions. Changes come
+// either from a Mongo change stream, when the store supports one, or are
+// published in-process by the resolvers after each successful write.
+type UserEvents struct {
+	mu        sync.Mutex
+	subs      map[chan UserEvent]struct{}
+	streaming bool
+}
+
+// NewUserEvents returns a hub with no subscribers that publishes
+// in-process until a change stream is attached.
+func NewUserEvents() *UserEvents {
+	return &UserEvents{subs: make(map[chan UserEvent]struct{})}
+}
+
+// Subscribe returns a channel of every event published from now until ctx
+// is done, at which point the channel is closed.
+func (e *UserEvents) Subscribe(ctx context.Context) <-chan UserEvent {
+	ch := make(chan UserEvent, subscriberBuffer)
+
+	e.mu.Lock()
+	e.subs[ch] = struct{}{}
+	e.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		e.mu.Lock()
+		delete(e.subs, ch)
+		close(ch)
+		e.mu.Unlock()
+	}()
+
+	return ch
+}
+
+// Publish delivers evt to every subscriber. Subscribers that are too far
+// behind miss the event rather than stall the publisher.
+func (e *UserEvents) Publish(evt UserEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for ch := range e.subs {
+		select {
+		case ch <- evt:
+		default:
+		}
+	}
+}
+
+// setStreaming records whether a change stream is feeding the hub.
+func (e *UserEvents) setStreaming(on bool) {
+	e.mu.Lock()
+	e.streaming = on
+	e.mu.Unlock()
+}
+
+// written is called by resolvers after a successful write. It publishes
+// the event in-process unless a change stream will deliver it instead.
+func (e *UserEvents) written(kind UserEventKind, users ...*supersimple.User) {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	streaming := e.streaming
+	e.mu.Unlock()
+
+	if streaming {
+		return
+	}
+	for _, u := range users {
+		e.Publish(UserEvent{Kind: kind, User: u})
+	}
+}
+
+// subscribe adapts the hub to a gqlgen subscription that delivers the
+// users of the events accepted by match.
+func (e *UserEvents) subscribe(ctx context.Context, match func(UserEvent) bool) <-chan *supersimple.User {
+	out := make(chan *supersimple.User, 1)
+
+	go func() {
+		defer close(out)
+		for evt := range e.Subscribe(ctx) {
+			if !match(evt) {
+				continue
+			}
+			select {
+			case out <- evt.User:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
@@ -0,0 +1,211 @@
+package supersimple
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"time"
+
+	supersimple "github.com/allen-woods/supersimple/models"
+	"go.mongodb.org/mongo-driver/bson"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The functions in this file translate UserFilter and UserOrderBy into
+// bson for MongoUserStore and into Go predicates for MemoryUserStore.
+// They do no I/O, so both translations can be checked side by side.
+
+// userFilterBSON translates f into a Mongo query document.
+func userFilterBSON(f supersimple.UserFilter) (bson.D, error) {
+	filter := bson.D{}
+
+	if !f.IncludeDeleted {
+		filter = append(filter, notDeleted)
+	}
+	if f.Ids != nil {
+		filter = append(filter, bson.E{Key: "_id", Value: bson.D{{Key: "$in", Value: f.Ids}}})
+	}
+	if r := timeRange(f.CreatedAfter, f.CreatedBefore); len(r) > 0 {
+		filter = append(filter, bson.E{Key: "createdAt", Value: r})
+	}
+	if r := timeRange(f.UpdatedAfter, f.UpdatedBefore); len(r) > 0 {
+		filter = append(filter, bson.E{Key: "updatedAt", Value: r})
+	}
+
+	if f.Name != nil {
+		filter = append(filter, bson.E{Key: "name", Value: *f.Name})
+	}
+
+	// $regex may appear only once per field, so pattern matches are
+	// combined with $and.
+	var patterns bson.A
+	if f.NameContains != nil {
+		patterns = append(patterns, bson.D{{Key: "name", Value: primitive.Regex{Pattern: regexp.QuoteMeta(*f.NameContains), Options: "i"}}})
+	}
+	if f.NamePrefix != nil {
+		patterns = append(patterns, bson.D{{Key: "name", Value: primitive.Regex{Pattern: "^" + regexp.QuoteMeta(*f.NamePrefix), Options: "i"}}})
+	}
+	if len(patterns) > 0 {
+		filter = append(filter, bson.E{Key: "$and", Value: patterns})
+	}
+
+	return filter, nil
+}
+
+// notDeleted matches users that are not soft-deleted. A null deletedAt
+// also matches documents without the field.
+var notDeleted = bson.E{Key: "deletedAt", Value: nil}
+
+// timeRange returns the bounds of a time field, inclusive of after and
+// exclusive of before.
+func timeRange(after, before *time.Time) bson.D {
+	r := bson.D{}
+	if after != nil {
+		r = append(r, bson.E{Key: "$gte", Value: *after})
+	}
+	if before != nil {
+		r = append(r, bson.E{Key: "$lt", Value: *before})
+	}
+	return r
+}
+
+// userMatcher returns the in-memory equivalent of userFilterBSON.
+func userMatcher(f supersimple.UserFilter) (func(*supersimple.User) bool, error) {
+	return func(u *supersimple.User) bool {
+		if !f.IncludeDeleted && u.DeletedAt != nil {
+			return false
+		}
+		if f.Ids != nil && !containsID(f.Ids, u.ID) {
+			return false
+		}
+		if !inRange(u.CreatedAt, f.CreatedAfter, f.CreatedBefore) {
+			return false
+		}
+		if !inRange(u.UpdatedAt, f.UpdatedAfter, f.UpdatedBefore) {
+			return false
+		}
+		if f.Name != nil && !strings.EqualFold(u.Name, *f.Name) {
+			return false
+		}
+		if f.NameContains != nil && !strings.Contains(strings.ToLower(u.Name), strings.ToLower(*f.NameContains)) {
+			return false
+		}
+		if f.NamePrefix != nil && !strings.HasPrefix(strings.ToLower(u.Name), strings.ToLower(*f.NamePrefix)) {
+			return false
+		}
+		return true
+	}, nil
+}
+
+// userCollation returns the collation that queries for f must use. Name
+// equality ignores case, as the unique name index does, and a query can
+// only use that index if it shares its collation.
+func userCollation(f supersimple.UserFilter) *options.Collation {
+	if f.Name != nil {
+		return nameCollation
+	}
+	return nil
+}
+
+// inRange is the in-memory equivalent of timeRange.
+func inRange(t time.Time, after, before *time.Time) bool {
+	if after != nil && t.Before(*after) {
+		return false
+	}
+	if before != nil && !t.Before(*before) {
+		return false
+	}
+	return true
+}
+
+// userSortField maps an order field onto the document key it sorts by.
+var userSortField = map[UserOrderField]string{
+	UserOrderFieldID:        "_id",
+	UserOrderFieldName:      "name",
+	UserOrderFieldCreatedAt: "createdAt",
+	UserOrderFieldUpdatedAt: "updatedAt",
+}
+
+// userSortBSON translates orderBy into a Mongo sort document. _id is
+// always appended as a tie-breaker so the order is total.
+func userSortBSON(orderBy []*UserOrderBy) bson.D {
+	sort := bson.D{}
+	seen := map[string]bool{}
+	for _, o := range orderBy {
+		key := userSortField[o.Field]
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		dir := 1
+		if o.Direction != nil && *o.Direction == OrderDirectionDesc {
+			dir = -1
+		}
+		sort = append(sort, bson.E{Key: key, Value: dir})
+	}
+	if !seen["_id"] {
+		sort = append(sort, bson.E{Key: "_id", Value: 1})
+	}
+	return sort
+}
+
+// userLess returns the in-memory equivalent of userSortBSON.
+func userLess(orderBy []*UserOrderBy) func(a, b *supersimple.User) bool {
+	sort := userSortBSON(orderBy)
+	return func(a, b *supersimple.User) bool {
+		for _, e := range sort {
+			var c int
+			switch e.Key {
+			case "_id":
+				c = compareIDs(a.ID, b.ID)
+			case "name":
+				c = strings.Compare(a.Name, b.Name)
+			case "createdAt":
+				c = compareTimes(a.CreatedAt, b.CreatedAt)
+			case "updatedAt":
+				c = compareTimes(a.UpdatedAt, b.UpdatedAt)
+			}
+			if c != 0 {
+				return (c < 0) == (e.Value.(int) > 0)
+			}
+		}
+		return false
+	}
+}
+
+func compareIDs(a, b primitive.ObjectID) int {
+	return bytes.Compare(a[:], b[:])
+}
+
+func compareTimes(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+func containsID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueIDs returns ids without duplicates, keeping the first occurrence.
+func uniqueIDs(ids []primitive.ObjectID) []primitive.ObjectID {
+	seen := make(map[primitive.ObjectID]bool, len(ids))
+	unique := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,299 @@
+package supersimple
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	supersimple "github.com/allen-woods/supersimple/models"
+	"go.mongodb.org/mongo-driver/bson"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func strPtr(s string) *string { return &s }
+
+func timePtr(t time.Time) *time.Time { return &t }
+
+var (
+	t0 = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 = t0.Add(time.Hour)
+	t2 = t0.Add(2 * time.Hour)
+
+	id1 = primitive.ObjectID{1}
+	id2 = primitive.ObjectID{2}
+)
+
+func TestUserFilterBSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter supersimple.UserFilter
+		want   bson.D
+	}{
+		{"empty", supersimple.UserFilter{}, bson.D{notDeleted}},
+		{"include deleted", supersimple.UserFilter{IncludeDeleted: true}, bson.D{}},
+		{"ids", supersimple.UserFilter{Ids: []primitive.ObjectID{id1, id2}}, bson.D{
+			notDeleted,
+			{Key: "_id", Value: bson.D{{Key: "$in", Value: []primitive.ObjectID{id1, id2}}}},
+		}},
+		{"name", supersimple.UserFilter{Name: strPtr("a.b")}, bson.D{
+			notDeleted,
+			{Key: "name", Value: "a.b"},
+		}},
+		{"name contains", supersimple.UserFilter{NameContains: strPtr("a.b(")}, bson.D{
+			notDeleted,
+			{Key: "$and", Value: bson.A{
+				bson.D{{Key: "name", Value: primitive.Regex{Pattern: `a\.b\(`, Options: "i"}}},
+			}},
+		}},
+		{"name prefix", supersimple.UserFilter{NamePrefix: strPtr("^a*")}, bson.D{
+			notDeleted,
+			{Key: "$and", Value: bson.A{
+				bson.D{{Key: "name", Value: primitive.Regex{Pattern: `^\^a\*`, Options: "i"}}},
+			}},
+		}},
+		{"name contains and prefix", supersimple.UserFilter{NameContains: strPtr("b"), NamePrefix: strPtr("a")}, bson.D{
+			notDeleted,
+			{Key: "$and", Value: bson.A{
+				bson.D{{Key: "name", Value: primitive.Regex{Pattern: "b", Options: "i"}}},
+				bson.D{{Key: "name", Value: primitive.Regex{Pattern: "^a", Options: "i"}}},
+			}},
+		}},
+		{"created", supersimple.UserFilter{CreatedAfter: timePtr(t0), CreatedBefore: timePtr(t1)}, bson.D{
+			notDeleted,
+			{Key: "createdAt", Value: bson.D{{Key: "$gte", Value: t0}, {Key: "$lt", Value: t1}}},
+		}},
+		{"created after", supersimple.UserFilter{CreatedAfter: timePtr(t0)}, bson.D{
+			notDeleted,
+			{Key: "createdAt", Value: bson.D{{Key: "$gte", Value: t0}}},
+		}},
+		{"updated", supersimple.UserFilter{UpdatedAfter: timePtr(t0), UpdatedBefore: timePtr(t1)}, bson.D{
+			notDeleted,
+			{Key: "updatedAt", Value: bson.D{{Key: "$gte", Value: t0}, {Key: "$lt", Value: t1}}},
+		}},
+		{"updated before", supersimple.UserFilter{UpdatedBefore: timePtr(t1)}, bson.D{
+			notDeleted,
+			{Key: "updatedAt", Value: bson.D{{Key: "$lt", Value: t1}}},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := userFilterBSON(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("userFilterBSON() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserCollation(t *testing.T) {
+	if c := userCollation(supersimple.UserFilter{NamePrefix: strPtr("a")}); c != nil {
+		t.Errorf("userCollation() without a name = %+v, want nil", c)
+	}
+	// Name lookups must share the collation of the unique name index, or
+	// they could neither use it nor agree with it on duplicates.
+	for _, spec := range DefaultIndexes {
+		if spec.Name == "name_unique" && userCollation(supersimple.UserFilter{Name: strPtr("a")}) != spec.Collation {
+			t.Errorf("userCollation() with a name differs from the collation of %s", spec.Name)
+		}
+	}
+}
+
+func TestUserMatcher(t *testing.T) {
+	user := &supersimple.User{ID: id1, Name: "Ann.Lee", CreatedAt: t1, UpdatedAt: t1}
+	deleted := &supersimple.User{ID: id2, Name: "Bob", CreatedAt: t1, UpdatedAt: t1, DeletedAt: timePtr(t2)}
+
+	tests := []struct {
+		name   string
+		filter supersimple.UserFilter
+		user   *supersimple.User
+		want   bool
+	}{
+		{"empty", supersimple.UserFilter{}, user, true},
+		{"deleted hidden", supersimple.UserFilter{}, deleted, false},
+		{"deleted included", supersimple.UserFilter{IncludeDeleted: true}, deleted, true},
+		{"deleted by id", supersimple.UserFilter{Ids: []primitive.ObjectID{id2}}, deleted, false},
+		{"ids match", supersimple.UserFilter{Ids: []primitive.ObjectID{id2, id1}}, user, true},
+		{"ids miss", supersimple.UserFilter{Ids: []primitive.ObjectID{id2}}, user, false},
+		{"ids empty", supersimple.UserFilter{Ids: []primitive.ObjectID{}}, user, false},
+		{"name", supersimple.UserFilter{Name: strPtr("Ann.Lee")}, user, true},
+		{"name ignores case", supersimple.UserFilter{Name: strPtr("ann.LEE")}, user, true},
+		{"name is whole", supersimple.UserFilter{Name: strPtr("Ann")}, user, false},
+		{"name contains ignores case", supersimple.UserFilter{NameContains: strPtr("N.l")}, user, true},
+		{"name contains is literal", supersimple.UserFilter{NameContains: strPtr("n.*e")}, user, false},
+		{"name prefix ignores case", supersimple.UserFilter{NamePrefix: strPtr("ann.")}, user, true},
+		{"name prefix is anchored", supersimple.UserFilter{NamePrefix: strPtr("Lee")}, user, false},
+		{"created after is inclusive", supersimple.UserFilter{CreatedAfter: timePtr(t1)}, user, true},
+		{"created after", supersimple.UserFilter{CreatedAfter: timePtr(t2)}, user, false},
+		{"created before is exclusive", supersimple.UserFilter{CreatedBefore: timePtr(t1)}, user, false},
+		{"created before", supersimple.UserFilter{CreatedBefore: timePtr(t2)}, user, true},
+		{"updated after is inclusive", supersimple.UserFilter{UpdatedAfter: timePtr(t1)}, user, true},
+		{"updated after", supersimple.UserFilter{UpdatedAfter: timePtr(t2)}, user, false},
+		{"updated before is exclusive", supersimple.UserFilter{UpdatedBefore: timePtr(t1)}, user, false},
+		{"updated before", supersimple.UserFilter{UpdatedBefore: timePtr(t2)}, user, true},
+		{"fields combine with and", supersimple.UserFilter{Name: strPtr("Ann.Lee"), NamePrefix: strPtr("Bob")}, user, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, err := userMatcher(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := match(tt.user); got != tt.want {
+				t.Errorf("userMatcher()(%q) = %v, want %v", tt.user.Name, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestNamePatternsAgree runs the regexes userFilterBSON sends to Mongo and
+// checks that they match the same names as userMatcher.
+func TestNamePatternsAgree(t *testing.T) {
+	names := []string{"a.b", "axb", "A.B", "xa.by", "a+b", "a", "(a)", "[a]", "$a^", `a\b`}
+	patterns := []string{"a.b", ".", "a+", "(a", "[a]", "$a^", `\`, "A"}
+
+	for _, p := range patterns {
+		for _, f := range []supersimple.UserFilter{{NameContains: strPtr(p)}, {NamePrefix: strPtr(p)}} {
+			doc, _ := userFilterBSON(f)
+			regex := doc[1].Value.(bson.A)[0].(bson.D)[0].Value.(primitive.Regex)
+			re := regexp.MustCompile("(?" + regex.Options + ")" + regex.Pattern)
+			match, _ := userMatcher(f)
+
+			for _, name := range names {
+				if got, want := re.MatchString(name), match(&supersimple.User{Name: name}); got != want {
+					t.Errorf("pattern %q on %q: regex %q matches %v, userMatcher %v", p, name, regex.Pattern, got, want)
+				}
+			}
+		}
+	}
+}
+
+func order(field UserOrderField, dir OrderDirection) *UserOrderBy {
+	return &UserOrderBy{Field: field, Direction: &dir}
+}
+
+func TestUserSortBSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		orderBy []*UserOrderBy
+		want    bson.D
+	}{
+		{"none", nil, bson.D{{Key: "_id", Value: 1}}},
+		{"default direction", []*UserOrderBy{{Field: UserOrderFieldName}}, bson.D{
+			{Key: "name", Value: 1}, {Key: "_id", Value: 1},
+		}},
+		{"desc", []*UserOrderBy{order(UserOrderFieldCreatedAt, OrderDirectionDesc)}, bson.D{
+			{Key: "createdAt", Value: -1}, {Key: "_id", Value: 1},
+		}},
+		{"id is not repeated", []*UserOrderBy{order(UserOrderFieldID, OrderDirectionDesc)}, bson.D{
+			{Key: "_id", Value: -1},
+		}},
+		{"first duplicate wins", []*UserOrderBy{
+			order(UserOrderFieldName, OrderDirectionDesc),
+			order(UserOrderFieldUpdatedAt, OrderDirectionAsc),
+			order(UserOrderFieldName, OrderDirectionAsc),
+		}, bson.D{
+			{Key: "name", Value: -1}, {Key: "updatedAt", Value: 1}, {Key: "_id", Value: 1},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := userSortBSON(tt.orderBy); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("userSortBSON() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestUserLessAgrees checks that userLess orders users as Mongo would by
+// the userSortBSON document, comparing the fields of the marshalled users.
+func TestUserLessAgrees(t *testing.T) {
+	users := []*supersimple.User{
+		{ID: primitive.ObjectID{5}, Name: "b", CreatedAt: t0, UpdatedAt: t2},
+		{ID: primitive.ObjectID{3}, Name: "a", CreatedAt: t1, UpdatedAt: t1},
+		{ID: primitive.ObjectID{4}, Name: "b", CreatedAt: t1, UpdatedAt: t0},
+		{ID: primitive.ObjectID{1}, Name: "a", CreatedAt: t0, UpdatedAt: t1},
+		{ID: primitive.ObjectID{2}, Name: "c", CreatedAt: t2, UpdatedAt: t0},
+	}
+
+	orders := [][]*UserOrderBy{
+		nil,
+		{order(UserOrderFieldID, OrderDirectionDesc)},
+		{order(UserOrderFieldName, OrderDirectionAsc)},
+		{order(UserOrderFieldName, OrderDirectionDesc)},
+		{order(UserOrderFieldCreatedAt, OrderDirectionAsc), order(UserOrderFieldName, OrderDirectionDesc)},
+		{order(UserOrderFieldUpdatedAt, OrderDirectionDesc), order(UserOrderFieldCreatedAt, OrderDirectionAsc)},
+		{order(UserOrderFieldName, OrderDirectionAsc), order(UserOrderFieldName, OrderDirectionDesc)},
+		{order(UserOrderFieldName, OrderDirectionAsc), order(UserOrderFieldID, OrderDirectionDesc)},
+	}
+
+	for _, orderBy := range orders {
+		sortDoc := userSortBSON(orderBy)
+
+		got := append([]*supersimple.User(nil), users...)
+		sort.SliceStable(got, func(i, j int) bool { return userLess(orderBy)(got[i], got[j]) })
+
+		want := append([]*supersimple.User(nil), users...)
+		sort.SliceStable(want, func(i, j int) bool { return bsonLess(t, sortDoc, want[i], want[j]) })
+
+		if !reflect.DeepEqual(ids(got), ids(want)) {
+			t.Errorf("sort %v: userLess gives %v, want %v", sortDoc, ids(got), ids(want))
+		}
+	}
+}
+
+// bsonLess compares the marshalled users by the keys of sortDoc.
+func bsonLess(t *testing.T, sortDoc bson.D, a, b *supersimple.User) bool {
+	da, db := marshalUser(t, a), marshalUser(t, b)
+	for _, e := range sortDoc {
+		va, vb := da.Lookup(e.Key), db.Lookup(e.Key)
+		var c int
+		switch va.Type {
+		case bson.TypeObjectID:
+			c = compareIDs(va.ObjectID(), vb.ObjectID())
+		case bson.TypeString:
+			switch {
+			case va.StringValue() < vb.StringValue():
+				c = -1
+			case va.StringValue() > vb.StringValue():
+				c = 1
+			}
+		case bson.TypeDateTime:
+			switch {
+			case va.DateTime() < vb.DateTime():
+				c = -1
+			case va.DateTime() > vb.DateTime():
+				c = 1
+			}
+		default:
+			t.Fatalf("cannot compare %s of type %v", e.Key, va.Type)
+		}
+		if c != 0 {
+			return c*e.Value.(int) < 0
+		}
+	}
+	return false
+}
+
+func marshalUser(t *testing.T, u *supersimple.User) bson.Raw {
+	b, err := bson.Marshal(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func ids(users []*supersimple.User) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	return ids
+}
@@ -0,0 +1,73 @@
+package supersimple
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IndexSpec describes an index that EnsureIndexes creates if it is missing.
+type IndexSpec struct {
+	Collection string
+	Name       string
+	Keys       bson.D
+	Unique     bool
+	// Collation, if set, decides how the indexed strings compare, and so
+	// which of them count as duplicates.
+	Collation *options.Collation
+}
+
+// nameCollation compares names ignoring case but not accents.
+var nameCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// DefaultIndexes are the indexes the stores rely on.
+//
+// Names are unique among live users, ignoring case. Soft-deleted users
+// keep their deletedAt in the key, so their names are free for reuse; a
+// restore that would reintroduce a duplicate fails instead.
+var DefaultIndexes = []IndexSpec{
+	{
+		Collection: "users",
+		Name:       "name_unique",
+		Keys:       bson.D{{Key: "name", Value: 1}, {Key: "deletedAt", Value: 1}},
+		Unique:     true,
+		Collation:  nameCollation,
+	},
+	{Collection: "users", Name: "createdAt", Keys: bson.D{{Key: "createdAt", Value: 1}}},
+	{Collection: "users", Name: "updatedAt", Keys: bson.D{{Key: "updatedAt", Value: 1}}},
+	{Collection: "users", Name: "deletedAt", Keys: bson.D{{Key: "deletedAt", Value: 1}}},
+	{Collection: "books", Name: "authorIds", Keys: bson.D{{Key: "authorIds", Value: 1}}},
+	{Collection: "audit", Name: "targetId", Keys: bson.D{{Key: "targetId", Value: 1}, {Key: "_id", Value: 1}}},
+}
+
+// EnsureIndexes creates every index in specs that does not exist yet.
+// Creating an index that already exists with the same definition is a
+// no-op, so it is safe to call on every startup. It fails if existing
+// documents violate a unique index.
+func (m *Mongo) EnsureIndexes(ctx context.Context, specs []IndexSpec) error {
+	var order []string
+	models := map[string][]mongo.IndexModel{}
+	for _, spec := range specs {
+		opts := options.Index().SetName(spec.Name)
+		if spec.Unique {
+			opts.SetUnique(true)
+		}
+		if spec.Collation != nil {
+			opts.SetCollation(spec.Collation)
+		}
+		if _, ok := models[spec.Collection]; !ok {
+			order = append(order, spec.Collection)
+		}
+		models[spec.Collection] = append(models[spec.Collection], mongo.IndexModel{Keys: spec.Keys, Options: opts})
+	}
+
+	for _, name := range order {
+		if _, err := m.Collection(name).Indexes().CreateMany(ctx, models[name]); err != nil {
+			return errors.Wrapf(err, "cannot create indexes on %s", name)
+		}
+	}
+	return nil
+}
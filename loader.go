@@ -0,0 +1,245 @@
+package supersimple
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	supersimple "github.com/allen-woods/supersimple/models"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// DefaultLoaderWait is how long a loader collects keys before it
+	// fetches them in one batch.
+	DefaultLoaderWait = 2 * time.Millisecond
+	defaultMaxBatch   = 100
+)
+
+// loaderStats is published as the expvar "dataloader", which the server
+// serves on its metrics listener only. For every loader it counts batches,
+// keys and batches per size bucket, which is what the wait window should
+// be tuned against.
+var loaderStats = expvar.NewMap("dataloader")
+
+var batchBuckets = []int{1, 2, 5, 10, 25, 50, 100}
+
+func recordBatch(name string, size int) {
+	loaderStats.Add(name+".batches", 1)
+	loaderStats.Add(name+".keys", int64(size))
+	for _, b := range batchBuckets {
+		if size <= b {
+			loaderStats.Add(name+".size_le_"+strconv.Itoa(b), 1)
+			return
+		}
+	}
+	loaderStats.Add(name+".size_gt_"+strconv.Itoa(batchBuckets[len(batchBuckets)-1]), 1)
+}
+
+type loaderResult struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// loader batches the ObjectID lookups made within wait of each other into
+// a single fetch, and caches every result for the life of the request.
+type loader struct {
+	name     string
+	ctx      context.Context
+	wait     time.Duration
+	maxBatch int
+	fetch    func(ctx context.Context, keys []primitive.ObjectID) (map[primitive.ObjectID]interface{}, error)
+
+	mu      sync.Mutex
+	cache   map[primitive.ObjectID]*loaderResult
+	pending map[primitive.ObjectID]*loaderResult
+	keys    []primitive.ObjectID
+}
+
+func (l *loader) load(key primitive.ObjectID) (interface{}, error) {
+	l.mu.Lock()
+	res, ok := l.cache[key]
+	if !ok {
+		res = &loaderResult{done: make(chan struct{})}
+		l.cache[key] = res
+		if l.pending == nil {
+			l.pending = make(map[primitive.ObjectID]*loaderResult)
+			time.AfterFunc(l.wait, l.dispatch)
+		}
+		l.pending[key] = res
+		l.keys = append(l.keys, key)
+		if len(l.keys) >= l.maxBatch {
+			l.mu.Unlock()
+			l.dispatch()
+			l.mu.Lock()
+		}
+	}
+	l.mu.Unlock()
+
+	<-res.done
+	return res.value, res.err
+}
+
+// dispatch fetches the pending batch, if it has not been fetched already.
+func (l *loader) dispatch() {
+	l.mu.Lock()
+	pending, keys := l.pending, l.keys
+	l.pending, l.keys = nil, nil
+	l.mu.Unlock()
+
+	if len(keys) == 0 {
+		return
+	}
+	recordBatch(l.name, len(keys))
+
+	values, err := l.fetchBatch(keys)
+	for key, res := range pending {
+		res.value, res.err = values[key], err
+		close(res.done)
+	}
+}
+
+// fetchBatch calls fetch, turning a panic into the masked internal error
+// that Recover makes of one. Batches dispatched by the timer run outside
+// any resolver, where a panic would otherwise kill the process.
+func (l *loader) fetchBatch(keys []primitive.ObjectID) (values map[primitive.ObjectID]interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			values, err = nil, Recover(l.ctx, p)
+		}
+	}()
+	return l.fetch(l.ctx, keys)
+}
+
+// forget drops key from the cache so that the next load fetches it again.
+func (l *loader) forget(key primitive.ObjectID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, pending := l.pending[key]; !pending {
+		delete(l.cache, key)
+	}
+}
+
+// reset drops every settled result from the cache.
+func (l *loader) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key := range l.cache {
+		if _, pending := l.pending[key]; !pending {
+			delete(l.cache, key)
+		}
+	}
+}
+
+// Loaders holds the per-request loaders used by field resolvers.
+type Loaders struct {
+	users         *loader
+	booksByAuthor *loader
+	authorsByBook *loader
+}
+
+// NewLoaders returns loaders that fetch through the given stores using
+// ctx, which should be the context of the request they serve.
+func NewLoaders(ctx context.Context, users UserStore, library LibraryStore, wait time.Duration) *Loaders {
+	if wait <= 0 {
+		wait = DefaultLoaderWait
+	}
+	newLoader := func(name string, fetch func(context.Context, []primitive.ObjectID) (map[primitive.ObjectID]interface{}, error)) *loader {
+		return &loader{
+			name:     name,
+			ctx:      ctx,
+			wait:     wait,
+			maxBatch: defaultMaxBatch,
+			fetch:    fetch,
+			cache:    make(map[primitive.ObjectID]*loaderResult),
+		}
+	}
+
+	return &Loaders{
+		users: newLoader("users", func(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]interface{}, error) {
+			found, err := users.Find(ctx, supersimple.UserFilter{Ids: ids}, nil)
+			results := make(map[primitive.ObjectID]interface{}, len(found))
+			for _, u := range found {
+				results[u.ID] = u
+			}
+			return results, err
+		}),
+		booksByAuthor: newLoader("booksByAuthor", func(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]interface{}, error) {
+			found, err := library.BooksByAuthor(ctx, ids)
+			results := make(map[primitive.ObjectID]interface{}, len(found))
+			for id, books := range found {
+				results[id] = books
+			}
+			return results, err
+		}),
+		authorsByBook: newLoader("authorsByBook", func(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]interface{}, error) {
+			found, err := library.AuthorsByBook(ctx, ids)
+			results := make(map[primitive.ObjectID]interface{}, len(found))
+			for id, authors := range found {
+				results[id] = authors
+			}
+			return results, err
+		}),
+	}
+}
+
+// User returns the user with the given id, or ErrNotFound.
+func (l *Loaders) User(id primitive.ObjectID) (*supersimple.User, error) {
+	v, err := l.users.load(id)
+	if err != nil {
+		return nil, err
+	}
+	u, _ := v.(*supersimple.User)
+	if u == nil {
+		return nil, ErrNotFound
+	}
+	return u, nil
+}
+
+// BooksByAuthor returns the books that list the given author.
+func (l *Loaders) BooksByAuthor(id primitive.ObjectID) ([]*supersimple.Book, error) {
+	v, err := l.booksByAuthor.load(id)
+	books, _ := v.([]*supersimple.Book)
+	return books, err
+}
+
+// AuthorsByBook returns the authors of the given book.
+func (l *Loaders) AuthorsByBook(id primitive.ObjectID) ([]*supersimple.Author, error) {
+	v, err := l.authorsByBook.load(id)
+	authors, _ := v.([]*supersimple.Author)
+	return authors, err
+}
+
+// resetLibrary drops every cached relationship, for use after a write to
+// authors or books.
+func (l *Loaders) resetLibrary() {
+	l.booksByAuthor.reset()
+	l.authorsByBook.reset()
+}
+
+type loadersKey struct{}
+
+// LoaderMiddleware installs a fresh set of Loaders into the context of
+// every request, so that batching and caching never cross requests.
+func LoaderMiddleware(users UserStore, library LibraryStore, wait time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		ctx = context.WithValue(ctx, loadersKey{}, NewLoaders(ctx, users, library, wait))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// loaders returns the request's Loaders. Outside LoaderMiddleware each
+// call gets its own, uncached set.
+func (r *Resolver) loaders(ctx context.Context) *Loaders {
+	if l, ok := ctx.Value(loadersKey{}).(*Loaders); ok {
+		return l
+	}
+	return NewLoaders(ctx, r.UserStore, r.LibraryStore, DefaultLoaderWait)
+}
@@ -0,0 +1,164 @@
+package supersimple
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	supersimple "github.com/allen-woods/supersimple/models"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// batchRecorder wraps the fetch of a loader to record every batch.
+type batchRecorder struct {
+	mu      sync.Mutex
+	batches [][]primitive.ObjectID
+}
+
+func (r *batchRecorder) wrap(l *loader) {
+	fetch := l.fetch
+	l.fetch = func(ctx context.Context, keys []primitive.ObjectID) (map[primitive.ObjectID]interface{}, error) {
+		r.mu.Lock()
+		r.batches = append(r.batches, append([]primitive.ObjectID(nil), keys...))
+		r.mu.Unlock()
+		return fetch(ctx, keys)
+	}
+}
+
+// take returns the batches recorded since the last call, with the keys of
+// each sorted.
+func (r *batchRecorder) take() [][]primitive.ObjectID {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	batches := r.batches
+	r.batches = nil
+	for _, b := range batches {
+		sort.Slice(b, func(i, j int) bool { return compareIDs(b[i], b[j]) < 0 })
+	}
+	return batches
+}
+
+// loadUsers loads every id concurrently, each twice, and returns the users
+// found and the errors, by id.
+func loadUsers(l *Loaders, ids []primitive.ObjectID) (map[primitive.ObjectID]*supersimple.User, map[primitive.ObjectID]error) {
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		found = map[primitive.ObjectID]*supersimple.User{}
+		errs  = map[primitive.ObjectID]error{}
+	)
+	for _, id := range append(append([]primitive.ObjectID(nil), ids...), ids...) {
+		wg.Add(1)
+		go func(id primitive.ObjectID) {
+			defer wg.Done()
+			u, err := l.User(id)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[id] = err
+			} else {
+				found[id] = u
+			}
+		}(id)
+	}
+	wg.Wait()
+	return found, errs
+}
+
+func TestLoaderBatchesAndCaches(t *testing.T) {
+	s, users := newTestUserStore(t, "a", "b", "c")
+	missing := primitive.NewObjectID()
+	ids := []primitive.ObjectID{users[0].ID, users[1].ID, users[2].ID, missing}
+
+	l := NewLoaders(context.Background(), s, NewMemoryLibraryStore(), 20*time.Millisecond)
+	var rec batchRecorder
+	rec.wrap(l.users)
+
+	found, errs := loadUsers(l, ids)
+	if b := rec.take(); len(b) != 1 || len(b[0]) != 4 {
+		t.Fatalf("fetched batches %v, want one of 4 keys", b)
+	}
+	for _, u := range users {
+		if found[u.ID] == nil || found[u.ID].Name != u.Name {
+			t.Errorf("User(%s) = %v, %v", u.ID.Hex(), found[u.ID], errs[u.ID])
+		}
+	}
+	if errs[missing] != ErrNotFound {
+		t.Errorf("User() of a missing id: got %v, want %v", errs[missing], ErrNotFound)
+	}
+
+	// Everything, including the miss, is now cached.
+	loadUsers(l, ids)
+	if b := rec.take(); len(b) != 0 {
+		t.Errorf("cached keys were fetched again: %v", b)
+	}
+
+	// A forgotten key is fetched again, and sees the store as it is now.
+	if _, err := s.UpdateName(context.Background(), users[1].ID, "bee", nil); err != nil {
+		t.Fatal(err)
+	}
+	l.users.forget(users[1].ID)
+	found, _ = loadUsers(l, ids)
+	if b := rec.take(); len(b) != 1 || len(b[0]) != 1 || b[0][0] != users[1].ID {
+		t.Errorf("fetched batches %v after forget, want only %s", b, users[1].ID.Hex())
+	}
+	if found[users[1].ID].Name != "bee" || found[users[0].ID].Name != "a" {
+		t.Errorf("after forget, loaded %v and %v", found[users[0].ID], found[users[1].ID])
+	}
+
+	// After a reset every key is fetched again, in one batch.
+	l.users.reset()
+	loadUsers(l, ids)
+	if b := rec.take(); len(b) != 1 || len(b[0]) != 4 {
+		t.Errorf("fetched batches %v after reset, want one of 4 keys", b)
+	}
+}
+
+func TestLoaderMaxBatch(t *testing.T) {
+	s, users := newTestUserStore(t, "a", "b", "c", "d", "e")
+	ids := make([]primitive.ObjectID, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+
+	l := NewLoaders(context.Background(), s, NewMemoryLibraryStore(), 20*time.Millisecond)
+	l.users.maxBatch = 2
+	var rec batchRecorder
+	rec.wrap(l.users)
+
+	found, errs := loadUsers(l, ids)
+	if len(found) != 5 || len(errs) != 0 {
+		t.Fatalf("loaded %d users, errors %v", len(found), errs)
+	}
+	keys := 0
+	for _, b := range rec.take() {
+		if len(b) > 2 {
+			t.Errorf("fetched a batch of %d keys, over the maximum of 2", len(b))
+		}
+		keys += len(b)
+	}
+	if keys != 5 {
+		t.Errorf("fetched %d keys, want 5", keys)
+	}
+}
+
+func TestLoaderRecoversFromPanic(t *testing.T) {
+	s, users := newTestUserStore(t, "a", "b")
+	ids := []primitive.ObjectID{users[0].ID, users[1].ID}
+
+	l := NewLoaders(context.Background(), s, NewMemoryLibraryStore(), time.Millisecond)
+	l.users.fetch = func(ctx context.Context, keys []primitive.ObjectID) (map[primitive.ObjectID]interface{}, error) {
+		panic("fetch failed")
+	}
+
+	_, errs := loadUsers(l, ids)
+	for _, id := range ids {
+		e, ok := errs[id].(*Error)
+		if !ok || e.Code != CodeInternal || e.CorrelationID == "" {
+			t.Errorf("User() after a panic: got %v, want an internal error", errs[id])
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package supersimple
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migration moves the database from Version-1 to Version, and Down moves
+// it back. A migration without Down cannot be rolled back.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, m *Mongo) error
+	Down        func(ctx context.Context, m *Mongo) error
+}
+
+// MigrationStatus is a known migration and when it was applied, if it was.
+type MigrationStatus struct {
+	Version     int
+	Description string
+	AppliedAt   *time.Time
+}
+
+// ErrMigrationLocked is returned when another process holds the migration
+// lock for longer than the caller was willing to wait.
+var ErrMigrationLocked = errors.New("migrations are locked by another process")
+
+const (
+	migrationsCollection = "migrations"
+	// migrationLockID is the _id of the lock document in the migrations
+	// collection. Every other document records an applied migration.
+	migrationLockID = "lock"
+	// DefaultMigrationLease is how long a lock is honoured before another
+	// process may assume its holder died and take it over.
+	DefaultMigrationLease = 10 * time.Minute
+	migrationLockPoll     = time.Second
+)
+
+// Migrator applies and rolls back migrations, recording its progress in
+// the "migrations" collection. Only one Migrator across every process
+// sharing the database runs at a time.
+type Migrator struct {
+	db         *Mongo
+	collection *mongo.Collection
+	migrations []Migration
+	owner      string
+	// Lease bounds how long the lock is held without being released.
+	Lease time.Duration
+}
+
+// NewMigrator returns a Migrator for migrations, which must be numbered
+// from 1 without gaps.
+func NewMigrator(m *Mongo, migrations []Migration) (*Migrator, error) {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, mig := range sorted {
+		if mig.Version != i+1 {
+			return nil, errors.Errorf("migration %d is missing or duplicated", i+1)
+		}
+		if mig.Up == nil {
+			return nil, errors.Errorf("migration %d has no Up", mig.Version)
+		}
+	}
+
+	return &Migrator{
+		db:         m,
+		collection: m.Collection(migrationsCollection),
+		migrations: sorted,
+		owner:      lockOwner(),
+		Lease:      DefaultMigrationLease,
+	}, nil
+}
+
+// Status lists every known migration in order.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(m.migrations))
+	for i, mig := range m.migrations {
+		status[i] = MigrationStatus{Version: mig.Version, Description: mig.Description}
+		if t, ok := applied[mig.Version]; ok {
+			t := t
+			status[i].AppliedAt = &t
+		}
+	}
+	return status, nil
+}
+
+// Up applies every pending migration up to and including target, or all
+// of them if target is 0, and returns the versions it applied.
+func (m *Migrator) Up(ctx context.Context, target int) ([]int, error) {
+	if target == 0 || target > len(m.migrations) {
+		target = len(m.migrations)
+	}
+
+	var done []int
+	err := m.locked(ctx, func() error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations[:target] {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if err := mig.Up(ctx, m.db); err != nil {
+				return errors.Wrapf(err, "migration %d (%s) failed", mig.Version, mig.Description)
+			}
+			record := bson.D{
+				{Key: "_id", Value: mig.Version},
+				{Key: "description", Value: mig.Description},
+				{Key: "appliedAt", Value: now()},
+			}
+			if _, err := m.collection.InsertOne(ctx, record); err != nil {
+				return errors.Wrapf(err, "migration %d was applied but could not be recorded", mig.Version)
+			}
+			done = append(done, mig.Version)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down rolls back every applied migration above target, newest first, and
+// returns the versions it rolled back.
+func (m *Migrator) Down(ctx context.Context, target int) ([]int, error) {
+	var done []int
+	err := m.locked(ctx, func() error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && m.migrations[i].Version > target; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+			if mig.Down == nil {
+				return errors.Errorf("migration %d (%s) cannot be rolled back", mig.Version, mig.Description)
+			}
+			if err := mig.Down(ctx, m.db); err != nil {
+				return errors.Wrapf(err, "rollback of migration %d (%s) failed", mig.Version, mig.Description)
+			}
+			if _, err := m.collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: mig.Version}}); err != nil {
+				return errors.Wrapf(err, "migration %d was rolled back but could not be recorded", mig.Version)
+			}
+			done = append(done, mig.Version)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// applied returns when each applied migration was applied.
+func (m *Migrator) applied(ctx context.Context) (map[int]time.Time, error) {
+	cur, err := m.collection.Find(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$ne", Value: migrationLockID}}}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	applied := map[int]time.Time{}
+	for cur.Next(ctx) {
+		var elem struct {
+			Version   int       `bson:"_id"`
+			AppliedAt time.Time `bson:"appliedAt"`
+		}
+		if err := cur.Decode(&elem); err != nil {
+			return nil, err
+		}
+		applied[elem.Version] = elem.AppliedAt
+	}
+	return applied, cur.Err()
+}
+
+// locked runs fn while holding the migration lock, waiting for it until
+// ctx is done.
+func (m *Migrator) locked(ctx context.Context, fn func() error) error {
+	for {
+		ok, err := m.lock(ctx)
+		if err != nil {
+			return err
+		}
+		if ok {
+			break
+		}
+		select {
+		case <-time.After(migrationLockPoll):
+		case <-ctx.Done():
+			return ErrMigrationLocked
+		}
+	}
+	defer m.unlock()
+
+	return fn()
+}
+
+// lock takes the lock if it is free or its lease has expired.
+func (m *Migrator) lock(ctx context.Context) (bool, error) {
+	t := now()
+	filter := bson.D{
+		{Key: "_id", Value: migrationLockID},
+		{Key: "expiresAt", Value: bson.D{{Key: "$lt", Value: t}}},
+	}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "owner", Value: m.owner},
+			{Key: "expiresAt", Value: t.Add(m.Lease)},
+		}},
+	}
+
+	// The upsert inserts the lock when there is none. When there is one
+	// that has not expired the filter misses it, and the upsert collides
+	// with its _id instead.
+	_, err := m.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if isDuplicateKey(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (m *Migrator) unlock() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	m.collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: migrationLockID}, {Key: "owner", Value: m.owner}})
+}
+
+// lockOwner identifies this process in the lock document.
+func lockOwner() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%s/%d/%s", host, os.Getpid(), hex.EncodeToString(b))
+}
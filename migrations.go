@@ -0,0 +1,76 @@
+package supersimple
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Migrations are the schema changes applied at startup and by the
+// "migrate" command. Append new ones; never renumber or edit applied ones.
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "backfill version, createdAt and updatedAt on users",
+		Up:          backfillUserTimestamps,
+	},
+}
+
+// backfillUserTimestamps gives users written before versioning and
+// timestamps a version of 1 and the creation time from their ObjectID.
+// It cannot be undone, as the backfilled values are indistinguishable
+// from real ones.
+func backfillUserTimestamps(ctx context.Context, m *Mongo) error {
+	users := m.Collection("users")
+
+	missing := bson.D{{Key: "version", Value: bson.D{{Key: "$exists", Value: false}}}}
+	set := bson.D{{Key: "$set", Value: bson.D{{Key: "version", Value: 1}}}}
+	if _, err := users.UpdateMany(ctx, missing, set); err != nil {
+		return err
+	}
+
+	missing = bson.D{{Key: "createdAt", Value: bson.D{{Key: "$exists", Value: false}}}}
+	cur, err := users.Find(ctx, missing)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	const batchSize = 500
+	var batch []mongo.WriteModel
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		_, err := users.BulkWrite(ctx, batch)
+		batch = batch[:0]
+		return err
+	}
+
+	for cur.Next(ctx) {
+		var elem struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cur.Decode(&elem); err != nil {
+			return err
+		}
+		t := elem.ID.Timestamp().UTC()
+		batch = append(batch, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: elem.ID}}).
+			SetUpdate(bson.D{{Key: "$set", Value: bson.D{
+				{Key: "createdAt", Value: t},
+				{Key: "updatedAt", Value: t},
+			}}}))
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
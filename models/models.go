@@ -1,11 +1,13 @@
 package supersimple
 
 import (
-	"errors"
 	"io"
 	"log"
+	"strconv"
+	"time"
 
 	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/gqlerror"
 	primitive "go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -13,11 +15,106 @@ type NewUser struct {
 	Name string
 }
 
+// User.Version starts at 1 and is incremented by every write, so that a
+// writer can detect that the document changed since it read it. The
+// stores maintain CreatedAt and UpdatedAt in the same way. DeletedAt is
+// set while the user is soft-deleted.
 type User struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	Name      string             `bson:"name"`
+	Version   int                `bson:"version"`
+	CreatedAt time.Time          `bson:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt"`
+	DeletedAt *time.Time         `bson:"deletedAt,omitempty"`
+}
+
+// UserInput is a complete user document, as written by replaceUser.
+type UserInput struct {
+	Name string
+}
+
+// UserFilter selects users. Unset fields match everything and set fields
+// combine with AND.
+type UserFilter struct {
+	Ids           []primitive.ObjectID
+	Name          *string
+	NameContains  *string
+	NamePrefix    *string
+	CreatedBefore *time.Time
+	CreatedAfter  *time.Time
+	UpdatedBefore *time.Time
+	UpdatedAfter  *time.Time
+
+	// IncludeDeleted also matches soft-deleted users. It is not part of
+	// the GraphQL input; resolvers set it from the includeDeleted argument.
+	IncludeDeleted bool
+}
+
+// IsEmpty reports whether f matches every user.
+func (f UserFilter) IsEmpty() bool {
+	return f.Ids == nil && f.Name == nil && f.NameContains == nil && f.NamePrefix == nil &&
+		f.CreatedBefore == nil && f.CreatedAfter == nil && f.UpdatedBefore == nil && f.UpdatedAfter == nil
+}
+
+// UserPatch holds the fields to change on a user. Unset fields are left alone.
+type UserPatch struct {
+	Name *string
+}
+
+// IsEmpty reports whether p changes nothing.
+func (p UserPatch) IsEmpty() bool {
+	return p.Name == nil
+}
+
+type NewAuthor struct {
+	Name string
+}
+
+type Author struct {
 	ID   primitive.ObjectID `bson:"_id,omitempty"`
 	Name string             `bson:"name"`
 }
 
+type NewBook struct {
+	Title     string
+	AuthorIds []primitive.ObjectID
+}
+
+// Book refers to its authors by ID. Author.books and Book.authors are
+// both resolved from AuthorIDs.
+type Book struct {
+	ID        primitive.ObjectID   `bson:"_id,omitempty"`
+	Title     string               `bson:"title"`
+	AuthorIDs []primitive.ObjectID `bson:"authorIds"`
+}
+
+// BookPatch holds the fields to change on a book. Unset fields are left alone.
+type BookPatch struct {
+	Title     *string
+	AuthorIds []primitive.ObjectID
+}
+
+// IsEmpty reports whether p changes nothing.
+func (p BookPatch) IsEmpty() bool {
+	return p.Title == nil && p.AuthorIds == nil
+}
+
+// AuditEntry records one write. Before and After are extended JSON
+// snapshots of the target, nil where it did not exist. Writes to every
+// user at once have no target. ActorVerified is false when the actor
+// named itself rather than authenticating.
+type AuditEntry struct {
+	ID            primitive.ObjectID  `bson:"_id,omitempty"`
+	Operation     string              `bson:"operation"`
+	Actor         string              `bson:"actor"`
+	ActorRole     string              `bson:"actorRole"`
+	ActorVerified bool                `bson:"actorVerified"`
+	TargetID      *primitive.ObjectID `bson:"targetId"`
+	Before        *string             `bson:"before"`
+	After         *string             `bson:"after"`
+	Timestamp     time.Time           `bson:"timestamp"`
+}
+
 func MarshalID(id primitive.ObjectID) graphql.Marshaler {
 	return graphql.WriterFunc(func(w io.Writer) {
 		json, err := id.MarshalJSON()
@@ -31,17 +128,44 @@ func MarshalID(id primitive.ObjectID) graphql.Marshaler {
 }
 
 func UnmarshalID(v interface{}) (primitive.ObjectID, error) {
-	var id primitive.ObjectID
-	var err error
-	err = nil
+	s, ok := v.(string)
+	if !ok {
+		return primitive.NilObjectID, validationError("ids must be strings")
+	}
+
+	id, err := primitive.ObjectIDFromHex(s)
+	if err != nil {
+		return primitive.NilObjectID, validationError("%q is not a valid id", s)
+	}
+
+	return id, nil
+}
 
-	json, ok := v.(string)
+// MarshalDateTime writes t as an RFC 3339 string in UTC.
+func MarshalDateTime(t time.Time) graphql.Marshaler {
+	return graphql.WriterFunc(func(w io.Writer) {
+		io.WriteString(w, strconv.Quote(t.UTC().Format(time.RFC3339Nano)))
+	})
+}
 
+func UnmarshalDateTime(v interface{}) (time.Time, error) {
+	s, ok := v.(string)
 	if !ok {
-		err = errors.New("ids must be strings")
-	} else {
-		err = id.UnmarshalJSON([]byte(json))
+		return time.Time{}, validationError("date-times must be strings")
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, validationError("%q is not an RFC 3339 date-time", s)
 	}
 
-	return id, err
+	return t, nil
+}
+
+// validationError reports a malformed scalar with the same extensions.code
+// the resolvers use for invalid input.
+func validationError(format string, args ...interface{}) error {
+	err := gqlerror.Errorf(format, args...)
+	err.Extensions = map[string]interface{}{"code": "VALIDATION"}
+	return err
 }
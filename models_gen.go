@@ -0,0 +1,156 @@
+// Code generated by github.com/99designs/gqlgen, DO NOT EDIT.
+
+package supersimple
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	supersimple "github.com/allen-woods/supersimple/models"
+)
+
+type AdminPayload struct {
+	Affected int  `json:"affected"`
+	DryRun   bool `json:"dryRun"`
+}
+
+type AuditConnection struct {
+	Edges    []*AuditEntryEdge `json:"edges"`
+	PageInfo *PageInfo         `json:"pageInfo"`
+}
+
+type AuditEntryEdge struct {
+	Cursor string                  `json:"cursor"`
+	Node   *supersimple.AuditEntry `json:"node"`
+}
+
+type BulkUserPayload struct {
+	Results   []*UserResult `json:"results"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+}
+
+type PageInfo struct {
+	HasNextPage     bool    `json:"hasNextPage"`
+	HasPreviousPage bool    `json:"hasPreviousPage"`
+	StartCursor     *string `json:"startCursor"`
+	EndCursor       *string `json:"endCursor"`
+}
+
+type ReplaceUserPayload struct {
+	User     *supersimple.User `json:"user"`
+	Inserted bool              `json:"inserted"`
+}
+
+type UserConnection struct {
+	Edges    []*UserEdge `json:"edges"`
+	PageInfo *PageInfo   `json:"pageInfo"`
+}
+
+type UserEdge struct {
+	Cursor string            `json:"cursor"`
+	Node   *supersimple.User `json:"node"`
+}
+
+type UserError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type UserOrderBy struct {
+	Field     UserOrderField  `json:"field"`
+	Direction *OrderDirection `json:"direction"`
+}
+
+type UserResult struct {
+	Index int               `json:"index"`
+	User  *supersimple.User `json:"user"`
+	Error *UserError        `json:"error"`
+}
+
+type OrderDirection string
+
+const (
+	OrderDirectionAsc  OrderDirection = "ASC"
+	OrderDirectionDesc OrderDirection = "DESC"
+)
+
+var AllOrderDirection = []OrderDirection{
+	OrderDirectionAsc,
+	OrderDirectionDesc,
+}
+
+func (e OrderDirection) IsValid() bool {
+	switch e {
+	case OrderDirectionAsc, OrderDirectionDesc:
+		return true
+	}
+	return false
+}
+
+func (e OrderDirection) String() string {
+	return string(e)
+}
+
+func (e *OrderDirection) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = OrderDirection(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid OrderDirection", str)
+	}
+	return nil
+}
+
+func (e OrderDirection) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type UserOrderField string
+
+const (
+	UserOrderFieldID        UserOrderField = "ID"
+	UserOrderFieldName      UserOrderField = "NAME"
+	UserOrderFieldCreatedAt UserOrderField = "CREATED_AT"
+	UserOrderFieldUpdatedAt UserOrderField = "UPDATED_AT"
+)
+
+var AllUserOrderField = []UserOrderField{
+	UserOrderFieldID,
+	UserOrderFieldName,
+	UserOrderFieldCreatedAt,
+	UserOrderFieldUpdatedAt,
+}
+
+func (e UserOrderField) IsValid() bool {
+	switch e {
+	case UserOrderFieldID, UserOrderFieldName, UserOrderFieldCreatedAt, UserOrderFieldUpdatedAt:
+		return true
+	}
+	return false
+}
+
+func (e UserOrderField) String() string {
+	return string(e)
+}
+
+func (e *UserOrderField) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = UserOrderField(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid UserOrderField", str)
+	}
+	return nil
+}
+
+func (e UserOrderField) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
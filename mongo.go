@@ -0,0 +1,87 @@
+package supersimple
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// MongoOptions configures the shared MongoDB connection.
+type MongoOptions struct {
+	URI         string
+	Database    string
+	MaxPoolSize uint64
+	MinPoolSize uint64
+	// OpTimeout bounds each database operation on top of whatever
+	// deadline the incoming request already carries. Zero means
+	// DefaultOpTimeout.
+	OpTimeout time.Duration
+	// Collections renames collections, keyed by the name the stores use,
+	// so that several deployments can share one database.
+	Collections map[string]string
+}
+
+// DefaultOpTimeout is used when MongoOptions.OpTimeout is unset.
+const DefaultOpTimeout = 10 * time.Second
+
+// Mongo owns a single pooled client for the lifetime of the server.
+// Every resolver borrows connections from it instead of dialling its own.
+type Mongo struct {
+	client  *mongo.Client
+	db      *mongo.Database
+	timeout time.Duration
+	names   map[string]string
+}
+
+// NewMongo connects to MongoDB and pings the primary so that a missing
+// database is reported on startup rather than on the first request.
+func NewMongo(ctx context.Context, opts MongoOptions) (*Mongo, error) {
+	clientOpts := options.Client().ApplyURI(opts.URI)
+	if opts.MaxPoolSize > 0 {
+		clientOpts.SetMaxPoolSize(opts.MaxPoolSize)
+	}
+	if opts.MinPoolSize > 0 {
+		clientOpts.SetMinPoolSize(opts.MinPoolSize)
+	}
+
+	client, err := mongo.Connect(ctx, clientOpts)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		client.Disconnect(ctx)
+		return nil, errors.Wrap(err, "no database was found, is MongoDB running?")
+	}
+
+	timeout := opts.OpTimeout
+	if timeout <= 0 {
+		timeout = DefaultOpTimeout
+	}
+
+	return &Mongo{client: client, db: client.Database(opts.Database), timeout: timeout, names: opts.Collections}, nil
+}
+
+// Collection returns a handle to the named collection in the configured
+// database, after any rename in MongoOptions.Collections.
+func (m *Mongo) Collection(name string) *mongo.Collection {
+	if renamed, ok := m.names[name]; ok {
+		name = renamed
+	}
+	return m.db.Collection(name)
+}
+
+// Disconnect closes every pooled connection. Call it once on shutdown.
+func (m *Mongo) Disconnect(ctx context.Context) error {
+	return m.client.Disconnect(ctx)
+}
+
+// opContext derives the context for a single database operation from the
+// request context, so that a cancelled or expired request stops its query.
+func (m *Mongo) opContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, m.timeout)
+}
@@ -0,0 +1,106 @@
+package supersimple
+
+import (
+	"encoding/base64"
+	"strings"
+
+	supersimple "github.com/allen-woods/supersimple/models"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Cursor prefixes keep a cursor from one connection from being accepted
+// by another.
+const (
+	userCursor  = "user:"
+	auditCursor = "audit:"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// encodeCursor returns an opaque cursor for a document. Cursors are based
+// on _id, so they stay valid while other documents are inserted.
+func encodeCursor(prefix string, id primitive.ObjectID) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(prefix + id.Hex()))
+}
+
+func decodeCursor(prefix string, cursor *string) (*primitive.ObjectID, error) {
+	if cursor == nil {
+		return nil, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(*cursor)
+	if err != nil || !strings.HasPrefix(string(b), prefix) {
+		return nil, errorf(CodeValidation, "invalid cursor %q", *cursor)
+	}
+
+	id, err := primitive.ObjectIDFromHex(strings.TrimPrefix(string(b), prefix))
+	if err != nil {
+		return nil, errorf(CodeValidation, "invalid cursor %q", *cursor)
+	}
+	return &id, nil
+}
+
+// pageSize validates the first/last pair of a connection field and returns
+// the page size and whether the page is taken from the end.
+func pageSize(first, last *int) (int, bool, error) {
+	switch {
+	case first != nil && last != nil:
+		return 0, false, errorf(CodeValidation, "first and last cannot be used together")
+	case first != nil:
+		if *first < 0 || *first > maxPageSize {
+			return 0, false, errorf(CodeValidation, "first must be between 0 and %d", maxPageSize)
+		}
+		return *first, false, nil
+	case last != nil:
+		if *last < 0 || *last > maxPageSize {
+			return 0, false, errorf(CodeValidation, "last must be between 0 and %d", maxPageSize)
+		}
+		return *last, true, nil
+	}
+	return defaultPageSize, false, nil
+}
+
+// userConnection builds a connection from one page of users. A cursor on
+// the opposite side of the page implies that users exist beyond it.
+func userConnection(users []*supersimple.User, more, fromEnd, hasAfter, hasBefore bool) *UserConnection {
+	conn := &UserConnection{
+		Edges: make([]*UserEdge, len(users)),
+		PageInfo: &PageInfo{
+			HasNextPage:     (!fromEnd && more) || (fromEnd && hasBefore),
+			HasPreviousPage: (fromEnd && more) || (!fromEnd && hasAfter),
+		},
+	}
+
+	for i, u := range users {
+		conn.Edges[i] = &UserEdge{Cursor: encodeCursor(userCursor, u.ID), Node: u}
+	}
+	if len(conn.Edges) > 0 {
+		conn.PageInfo.StartCursor = &conn.Edges[0].Cursor
+		conn.PageInfo.EndCursor = &conn.Edges[len(conn.Edges)-1].Cursor
+	}
+	return conn
+}
+
+// auditConnection builds a connection from one page of audit entries.
+// The log is only paged forwards.
+func auditConnection(entries []*supersimple.AuditEntry, more, hasAfter bool) *AuditConnection {
+	conn := &AuditConnection{
+		Edges: make([]*AuditEntryEdge, len(entries)),
+		PageInfo: &PageInfo{
+			HasNextPage:     more,
+			HasPreviousPage: hasAfter,
+		},
+	}
+
+	for i, e := range entries {
+		conn.Edges[i] = &AuditEntryEdge{Cursor: encodeCursor(auditCursor, e.ID), Node: e}
+	}
+	if len(conn.Edges) > 0 {
+		conn.PageInfo.StartCursor = &conn.Edges[0].Cursor
+		conn.PageInfo.EndCursor = &conn.Edges[len(conn.Edges)-1].Cursor
+	}
+	return conn
+}
@@ -0,0 +1,38 @@
+package supersimple
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	// DefaultRetention is how long soft-deleted users are kept before
+	// PurgeDeleted removes them for good.
+	DefaultRetention = 30 * 24 * time.Hour
+	// DefaultPurgeInterval is how often PurgeDeleted runs.
+	DefaultPurgeInterval = time.Hour
+)
+
+// PurgeDeleted permanently removes the users soft-deleted more than
+// retention ago, straight away and then every interval until ctx is done.
+func PurgeDeleted(ctx context.Context, users UserStore, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := users.Purge(ctx, time.Now().Add(-retention))
+		if err != nil && ctx.Err() == nil {
+			log.Println("purge of deleted users failed:", err)
+		}
+		if n > 0 {
+			log.Printf("purged %d deleted users", n)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -2,15 +2,10 @@ package supersimple
 
 import (
 	"context"
-	"log"
-	"time"
 
 	supersimple "github.com/allen-woods/supersimple/models"
 	"go.mongodb.org/mongo-driver/bson"
 	primitive "go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-	"go.mongodb.org/mongo-driver/mongo/readpref"
 ) // THIS CODE IS A STARTING POINT ONLY. IT WILL NOT BE UPDATED WITH SCHEMA CHANGES.
 
 // RESTful Routes
@@ -57,145 +52,559 @@ Update - all
 Delete - many
 Delete - all
 */
-func GoMongo(db string, col string) (context.Context, *mongo.Collection) {
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
-	err = client.Ping(ctx, readpref.Primary())
 
-	if err != nil {
-		log.Fatal("No database was found. Is MongoDB running?")
-	}
-
-	collection := client.Database(db).Collection(col)
-
-	return ctx, collection
+type Resolver struct {
+	UserStore    UserStore
+	LibraryStore LibraryStore
+	Events       *UserEvents
+	Audit        AuditStore
 }
 
-type Resolver struct{}
-
+func (r *Resolver) Author() AuthorResolver {
+	return &authorResolver{r}
+}
+func (r *Resolver) Book() BookResolver {
+	return &bookResolver{r}
+}
 func (r *Resolver) Mutation() MutationResolver {
 	return &mutationResolver{r}
 }
 func (r *Resolver) Query() QueryResolver {
 	return &queryResolver{r}
 }
+func (r *Resolver) Subscription() SubscriptionResolver {
+	return &subscriptionResolver{r}
+}
+
+type authorResolver struct{ *Resolver }
+
+func (r *authorResolver) Books(ctx context.Context, obj *supersimple.Author) ([]*supersimple.Book, error) {
+	books, err := r.loaders(ctx).BooksByAuthor(obj.ID)
+	if err != nil {
+		return nil, storeError("book", err)
+	}
+	return books, nil
+}
+
+type bookResolver struct{ *Resolver }
+
+func (r *bookResolver) Authors(ctx context.Context, obj *supersimple.Book) ([]*supersimple.Author, error) {
+	authors, err := r.loaders(ctx).AuthorsByBook(obj.ID)
+	if err != nil {
+		return nil, storeError("author", err)
+	}
+	return authors, nil
+}
 
 type mutationResolver struct{ *Resolver }
 
 func (r *mutationResolver) CreateUser(ctx context.Context, input supersimple.NewUser) (*supersimple.User, error) {
-	ctx, collection := GoMongo("simple", "users")
-
 	u := &supersimple.User{
 		Name: input.Name,
 	}
 
-	res, err := collection.InsertOne(ctx, *u)
+	if err := r.UserStore.Insert(ctx, u); err != nil {
+		return nil, storeError("user", err)
+	}
+	r.Events.written(UserCreated, u)
+	r.audit(ctx, "createUser", &u.ID, nil, u)
+	return u, nil
+}
+
+func (r *mutationResolver) UpdateUser(ctx context.Context, id primitive.ObjectID, name string, expectedVersion *int) (*supersimple.User, error) {
+	defer r.loaders(ctx).users.forget(id)
+
+	before := r.userBefore(ctx, id)
+	u, err := r.UserStore.UpdateName(ctx, id, name, expectedVersion)
 	if err != nil {
-		log.Println(err)
+		return nil, storeError("user", err)
 	}
-	id := res.InsertedID
-	u.ID = id.(primitive.ObjectID)
+	r.Events.written(UserUpdated, u)
+	r.audit(ctx, "updateUser", &id, before, u)
 	return u, nil
 }
 
-func (r *mutationResolver) UpdateUser(ctx context.Context, id primitive.ObjectID, name string) (*supersimple.User, error) {
-	ctx, collection := GoMongo("simple", "users")
+func (r *mutationResolver) DeleteUser(ctx context.Context, id primitive.ObjectID, expectedVersion *int) (*supersimple.User, error) {
+	defer r.loaders(ctx).users.forget(id)
 
-	filter := bson.D{
-		{"_id", id},
+	before := r.userBefore(ctx, id)
+	u, err := r.UserStore.Delete(ctx, id, expectedVersion)
+	if err != nil {
+		return nil, storeError("user", err)
 	}
+	r.Events.written(UserDeleted, u)
+	r.audit(ctx, "deleteUser", &id, before, u)
+	return u, nil
+}
 
-	update := bson.D{
-		{
-			"$set", bson.D{
-				{"name", name},
-			},
-		},
+// RestoreUser undoes deleteUser for a user that has not been purged yet.
+func (r *mutationResolver) RestoreUser(ctx context.Context, id primitive.ObjectID) (*supersimple.User, error) {
+	defer r.loaders(ctx).users.forget(id)
+
+	before := r.userBefore(ctx, id)
+	u, err := r.UserStore.Restore(ctx, id)
+	if err != nil {
+		return nil, storeError("deleted user", err)
 	}
+	r.Events.written(UserUpdated, u)
+	r.audit(ctx, "restoreUser", &id, before, u)
+	return u, nil
+}
 
-	opts := options.FindOneAndUpdate()
-	opts.SetReturnDocument(options.After)
+// ReplaceUser gives updateUser PUT semantics: every field is overwritten,
+// and with upsert a missing user is created under the given id.
+func (r *mutationResolver) ReplaceUser(ctx context.Context, id primitive.ObjectID, input supersimple.UserInput, upsert *bool, expectedVersion *int) (*ReplaceUserPayload, error) {
+	defer r.loaders(ctx).users.forget(id)
 
-	var u supersimple.User
+	u := &supersimple.User{
+		ID:   id,
+		Name: input.Name,
+	}
 
-	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&u)
+	before := r.userBefore(ctx, id)
+	inserted, err := r.UserStore.Replace(ctx, u, upsert != nil && *upsert, expectedVersion)
 	if err != nil {
-		log.Println("Error:", err)
+		return nil, storeError("user", err)
+	}
+	r.audit(ctx, "replaceUser", &id, before, u)
+	if inserted {
+		r.Events.written(UserCreated, u)
+	} else {
+		r.Events.written(UserUpdated, u)
 	}
-	return &u, nil
+	return &ReplaceUserPayload{User: u, Inserted: inserted}, nil
 }
 
-func (r *mutationResolver) DeleteUser(ctx context.Context, id primitive.ObjectID) (*supersimple.User, error) {
-	ctx, collection := GoMongo("simple", "users")
+func (r *mutationResolver) CreateUsers(ctx context.Context, input []*supersimple.NewUser) (*BulkUserPayload, error) {
+	users := make([]*supersimple.User, len(input))
+	for i, in := range input {
+		users[i] = &supersimple.User{
+			Name: in.Name,
+		}
+	}
 
-	filter := bson.D{
-		{"_id", id},
+	errs, err := r.UserStore.InsertMany(ctx, users)
+	if err != nil {
+		return nil, storeError("user", err)
 	}
 
-	var u supersimple.User
+	payload := &BulkUserPayload{}
+	for i, u := range users {
+		if errs[i] != nil {
+			payload.add(i, nil, storeError("user", errs[i]))
+		} else {
+			payload.add(i, u, nil)
+			r.Events.written(UserCreated, u)
+			r.audit(ctx, "createUsers", &u.ID, nil, u)
+		}
+	}
+	return payload, nil
+}
 
-	err := collection.FindOneAndDelete(ctx, filter).Decode(&u)
+func (r *mutationResolver) UpdateUsers(ctx context.Context, filter supersimple.UserFilter, patch supersimple.UserPatch) (*BulkUserPayload, error) {
+	if filter.IsEmpty() {
+		return nil, errorf(CodeValidation, "filter must not be empty")
+	}
+	if patch.IsEmpty() {
+		return nil, errorf(CodeValidation, "patch must set at least one field")
+	}
+
+	defer r.loaders(ctx).users.reset()
+
+	before := r.usersBefore(ctx, filter)
+	users, err := r.UserStore.UpdateMany(ctx, filter, patch)
 	if err != nil {
-		log.Println("Error:", err)
+		return nil, storeError("user", err)
 	}
-	return &u, nil
+
+	r.Events.written(UserUpdated, users...)
+
+	payload := &BulkUserPayload{}
+	for i, u := range users {
+		payload.add(i, u, nil)
+		r.audit(ctx, "updateUsers", &u.ID, before[u.ID], u)
+	}
+	return payload, nil
 }
 
-type queryResolver struct{ *Resolver }
+func (r *mutationResolver) DeleteUsers(ctx context.Context, ids []primitive.ObjectID) (*BulkUserPayload, error) {
+	defer r.loaders(ctx).users.reset()
 
-func (r *queryResolver) OneUser(ctx context.Context, id *primitive.ObjectID, name *string) (*supersimple.User, error) {
-	ctx, collection := GoMongo("simple", "users")
+	before := r.usersBefore(ctx, supersimple.UserFilter{Ids: ids})
+	users, err := r.UserStore.DeleteMany(ctx, ids)
+	if err != nil {
+		return nil, storeError("user", err)
+	}
 
-	var filter bson.D
+	r.Events.written(UserDeleted, users...)
 
-	if id != nil {
-		filter = bson.D{
-			{"_id", id},
-		}
-	} else if name != nil {
-		filter = bson.D{
-			{"name", name},
-		}
-	} else if id != nil && name != nil {
-		filter = bson.D{
-			{"_id", id},
-			{"name", name},
+	deleted := make(map[primitive.ObjectID]*supersimple.User, len(users))
+	for _, u := range users {
+		deleted[u.ID] = u
+	}
+
+	payload := &BulkUserPayload{}
+	for i, id := range ids {
+		if u, ok := deleted[id]; ok {
+			payload.add(i, u, nil)
+			r.audit(ctx, "deleteUsers", &u.ID, before[u.ID], u)
+			delete(deleted, id)
+		} else {
+			payload.add(i, nil, storeError("user", ErrNotFound))
 		}
 	}
+	return payload, nil
+}
+
+func (r *mutationResolver) CreateAuthor(ctx context.Context, input supersimple.NewAuthor) (*supersimple.Author, error) {
+	a := &supersimple.Author{
+		Name: input.Name,
+	}
+
+	if err := r.LibraryStore.InsertAuthor(ctx, a); err != nil {
+		return nil, storeError("author", err)
+	}
+	r.audit(ctx, "createAuthor", &a.ID, nil, a)
+	return a, nil
+}
+
+func (r *mutationResolver) UpdateAuthor(ctx context.Context, id primitive.ObjectID, name string) (*supersimple.Author, error) {
+	defer r.loaders(ctx).resetLibrary()
+
+	before := r.authorBefore(ctx, id)
+	a, err := r.LibraryStore.UpdateAuthorName(ctx, id, name)
+	if err != nil {
+		return nil, storeError("author", err)
+	}
+	r.audit(ctx, "updateAuthor", &id, before, a)
+	return a, nil
+}
+
+func (r *mutationResolver) DeleteAuthor(ctx context.Context, id primitive.ObjectID) (*supersimple.Author, error) {
+	defer r.loaders(ctx).resetLibrary()
+
+	a, err := r.LibraryStore.DeleteAuthor(ctx, id)
+	if err != nil {
+		return nil, storeError("author", err)
+	}
+	r.audit(ctx, "deleteAuthor", &id, a, nil)
+	return a, nil
+}
+
+func (r *mutationResolver) CreateBook(ctx context.Context, input supersimple.NewBook) (*supersimple.Book, error) {
+	b := &supersimple.Book{
+		Title:     input.Title,
+		AuthorIDs: uniqueIDs(input.AuthorIds),
+	}
+
+	defer r.loaders(ctx).resetLibrary()
+
+	if err := r.LibraryStore.InsertBook(ctx, b); err != nil {
+		return nil, bookError(err)
+	}
+	r.audit(ctx, "createBook", &b.ID, nil, b)
+	return b, nil
+}
+
+func (r *mutationResolver) UpdateBook(ctx context.Context, id primitive.ObjectID, patch supersimple.BookPatch) (*supersimple.Book, error) {
+	if patch.IsEmpty() {
+		return nil, errorf(CodeValidation, "patch must set at least one field")
+	}
+	if patch.AuthorIds != nil {
+		patch.AuthorIds = uniqueIDs(patch.AuthorIds)
+	}
 
-	var u supersimple.User
+	defer r.loaders(ctx).resetLibrary()
 
-	err := collection.FindOne(ctx, filter).Decode(&u)
+	before := r.bookBefore(ctx, id)
+	b, err := r.LibraryStore.UpdateBook(ctx, id, patch)
 	if err != nil {
-		log.Println("Error:", err)
+		return nil, bookError(err)
 	}
-	return &u, nil
+	r.audit(ctx, "updateBook", &id, before, b)
+	return b, nil
 }
 
-func (r *queryResolver) Users(ctx context.Context) ([]*supersimple.User, error) {
-	ctx, collection := GoMongo("simple", "users")
+func (r *mutationResolver) DeleteBook(ctx context.Context, id primitive.ObjectID) (*supersimple.Book, error) {
+	defer r.loaders(ctx).resetLibrary()
+
+	b, err := r.LibraryStore.DeleteBook(ctx, id)
+	if err != nil {
+		return nil, storeError("book", err)
+	}
+	r.audit(ctx, "deleteBook", &id, b, nil)
+	return b, nil
+}
+
+func bookError(err error) error {
+	if err == ErrUnknownReference {
+		return errorf(CodeValidation, "authorIds refers to an author that does not exist")
+	}
+	return storeError("book", err)
+}
+
+// Confirmation phrases for the mutations that touch every user.
+const (
+	DeleteAllUsersConfirmation = "DELETE ALL USERS"
+	UpdateAllUsersConfirmation = "UPDATE ALL USERS"
+)
+
+func (r *mutationResolver) DeleteAllUsers(ctx context.Context, confirm *string, dryRun *bool) (*AdminPayload, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	if dryRun != nil && *dryRun {
+		n, err := r.UserStore.Count(ctx, supersimple.UserFilter{})
+		if err != nil {
+			return nil, storeError("user", err)
+		}
+		return &AdminPayload{Affected: int(n), DryRun: true}, nil
+	}
+
+	if confirm == nil || *confirm != DeleteAllUsersConfirmation {
+		return nil, errorf(CodeValidation, "confirm must be %q", DeleteAllUsersConfirmation)
+	}
 
-	var results []*supersimple.User
+	defer r.loaders(ctx).users.reset()
 
-	cur, err := collection.Find(ctx, bson.D{})
+	users, err := r.UserStore.DeleteAll(ctx)
 	if err != nil {
-		log.Println(err)
+		return nil, storeError("user", err)
 	}
+	r.Events.written(UserDeleted, users...)
+	r.audit(ctx, "deleteAllUsers", nil, nil, bson.D{{Key: "affected", Value: len(users)}})
+	return &AdminPayload{Affected: len(users)}, nil
+}
 
-	defer cur.Close(ctx)
+func (r *mutationResolver) UpdateAllUsers(ctx context.Context, patch supersimple.UserPatch, confirm *string, dryRun *bool) (*AdminPayload, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if patch.IsEmpty() {
+		return nil, errorf(CodeValidation, "patch must set at least one field")
+	}
 
-	for cur.Next(ctx) {
-		var elem supersimple.User
-		err := cur.Decode(&elem)
+	if dryRun != nil && *dryRun {
+		n, err := r.UserStore.Count(ctx, supersimple.UserFilter{})
 		if err != nil {
-			log.Println(err)
+			return nil, storeError("user", err)
 		}
-		results = append(results, &elem)
+		return &AdminPayload{Affected: int(n), DryRun: true}, nil
+	}
+
+	if confirm == nil || *confirm != UpdateAllUsersConfirmation {
+		return nil, errorf(CodeValidation, "confirm must be %q", UpdateAllUsersConfirmation)
+	}
+
+	defer r.loaders(ctx).users.reset()
+
+	users, err := r.UserStore.UpdateAll(ctx, patch)
+	if err != nil {
+		return nil, storeError("user", err)
+	}
+	r.Events.written(UserUpdated, users...)
+	r.audit(ctx, "updateAllUsers", nil, nil, bson.D{{Key: "patch", Value: patch}, {Key: "affected", Value: len(users)}})
+	return &AdminPayload{Affected: len(users)}, nil
+}
+
+// add records the outcome for the input item at index.
+func (p *BulkUserPayload) add(index int, u *supersimple.User, err error) {
+	result := &UserResult{Index: index, User: u}
+	if err != nil {
+		e := clientError(err)
+		result.Error = &UserError{Code: string(e.Code), Message: e.Message}
+		p.Failed++
+	} else {
+		p.Succeeded++
+	}
+	p.Results = append(p.Results, result)
+}
+
+type queryResolver struct{ *Resolver }
+
+// OneUser looks a user up by id, name or both. Both arguments combine with
+// AND, so a user is returned only if it matches every argument given.
+func (r *queryResolver) OneUser(ctx context.Context, id *primitive.ObjectID, name *string, includeDeleted *bool) (*supersimple.User, error) {
+	if id == nil && name == nil {
+		return nil, errorf(CodeValidation, "oneUser requires an id or a name")
 	}
 
-	if err := cur.Err(); err != nil {
-		log.Println(err)
+	filter := supersimple.UserFilter{Name: name}
+	if err := withDeleted(ctx, &filter, includeDeleted); err != nil {
+		return nil, err
 	}
 
+	var u *supersimple.User
+	var err error
+	if name == nil && !filter.IncludeDeleted {
+		u, err = r.loaders(ctx).User(*id)
+	} else {
+		if id != nil {
+			filter.Ids = []primitive.ObjectID{*id}
+		}
+		u, err = r.UserStore.FindOne(ctx, filter)
+	}
+
+	switch err {
+	case nil:
+		return u, nil
+	case ErrNotFound:
+		return nil, nil
+	case ErrAmbiguous:
+		return nil, errorf(CodeConflict, "more than one user is named %q, look the user up by id instead", *name)
+	}
+	return nil, storeError("user", err)
+}
+
+func (r *queryResolver) Users(ctx context.Context, filter *supersimple.UserFilter, orderBy []*UserOrderBy, includeDeleted *bool) ([]*supersimple.User, error) {
+	if filter == nil {
+		filter = &supersimple.UserFilter{}
+	}
+	if err := withDeleted(ctx, filter, includeDeleted); err != nil {
+		return nil, err
+	}
+
+	results, err := r.UserStore.Find(ctx, *filter, orderBy)
+	if err != nil {
+		return nil, storeError("user", err)
+	}
 	return results, nil
 }
+
+func (r *queryResolver) UsersConnection(ctx context.Context, filter *supersimple.UserFilter, first *int, after *string, last *int, before *string, includeDeleted *bool) (*UserConnection, error) {
+	if filter == nil {
+		filter = &supersimple.UserFilter{}
+	}
+	if err := withDeleted(ctx, filter, includeDeleted); err != nil {
+		return nil, err
+	}
+
+	limit, fromEnd, err := pageSize(first, last)
+	if err != nil {
+		return nil, err
+	}
+	afterID, err := decodeCursor(userCursor, after)
+	if err != nil {
+		return nil, err
+	}
+	beforeID, err := decodeCursor(userCursor, before)
+	if err != nil {
+		return nil, err
+	}
+
+	users, more, err := r.UserStore.Page(ctx, *filter, afterID, beforeID, limit, fromEnd)
+	if err != nil {
+		return nil, storeError("user", err)
+	}
+
+	return userConnection(users, more, fromEnd, afterID != nil, beforeID != nil), nil
+}
+
+// AuditLog pages through the audit log, optionally only the entries about
+// one target.
+func (r *queryResolver) AuditLog(ctx context.Context, targetID *primitive.ObjectID, first *int, after *string) (*AuditConnection, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if r.Audit == nil {
+		return nil, errorf(CodeInternal, "the audit log is not enabled on this server")
+	}
+
+	limit, _, err := pageSize(first, nil)
+	if err != nil {
+		return nil, err
+	}
+	afterID, err := decodeCursor(auditCursor, after)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, more, err := r.Audit.Page(ctx, targetID, afterID, limit)
+	if err != nil {
+		return nil, storeError("audit entry", err)
+	}
+	return auditConnection(entries, more, afterID != nil), nil
+}
+
+// withDeleted applies the includeDeleted argument to filter. Only admins
+// may see soft-deleted users.
+func withDeleted(ctx context.Context, filter *supersimple.UserFilter, includeDeleted *bool) error {
+	if includeDeleted == nil || !*includeDeleted {
+		return nil
+	}
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+	filter.IncludeDeleted = true
+	return nil
+}
+
+func (r *queryResolver) Author(ctx context.Context, id primitive.ObjectID) (*supersimple.Author, error) {
+	a, err := r.LibraryStore.FindAuthor(ctx, id)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, storeError("author", err)
+	}
+	return a, nil
+}
+
+func (r *queryResolver) Authors(ctx context.Context) ([]*supersimple.Author, error) {
+	authors, err := r.LibraryStore.FindAuthors(ctx)
+	if err != nil {
+		return nil, storeError("author", err)
+	}
+	return authors, nil
+}
+
+func (r *queryResolver) Book(ctx context.Context, id primitive.ObjectID) (*supersimple.Book, error) {
+	b, err := r.LibraryStore.FindBook(ctx, id)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, storeError("book", err)
+	}
+	return b, nil
+}
+
+func (r *queryResolver) Books(ctx context.Context) ([]*supersimple.Book, error) {
+	books, err := r.LibraryStore.FindBooks(ctx)
+	if err != nil {
+		return nil, storeError("book", err)
+	}
+	return books, nil
+}
+
+type subscriptionResolver struct{ *Resolver }
+
+var errNoEvents = errorf(CodeInternal, "subscriptions are not enabled on this server")
+
+func (r *subscriptionResolver) UserCreated(ctx context.Context) (<-chan *supersimple.User, error) {
+	if r.Events == nil {
+		return nil, errNoEvents
+	}
+	return r.Events.subscribe(ctx, func(evt UserEvent) bool {
+		return evt.Kind == UserCreated
+	}), nil
+}
+
+func (r *subscriptionResolver) UserUpdated(ctx context.Context, id *primitive.ObjectID) (<-chan *supersimple.User, error) {
+	if r.Events == nil {
+		return nil, errNoEvents
+	}
+	return r.Events.subscribe(ctx, func(evt UserEvent) bool {
+		return evt.Kind == UserUpdated && (id == nil || evt.User.ID == *id)
+	}), nil
+}
+
+func (r *subscriptionResolver) UserDeleted(ctx context.Context) (<-chan *supersimple.User, error) {
+	if r.Events == nil {
+		return nil, errNoEvents
+	}
+	return r.Events.subscribe(ctx, func(evt UserEvent) bool {
+		return evt.Kind == UserDeleted
+	}), nil
+}
@@ -0,0 +1,249 @@
+package supersimple
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/99designs/gqlgen/handler"
+	supersimple "github.com/allen-woods/supersimple/models"
+)
+
+// adminToken authenticates an admin to the handler of newTestHandler.
+const adminToken = "secret"
+
+// newTestHandler serves the schema over memory stores, as the server does.
+func newTestHandler() http.Handler {
+	h, _ := newTestServer()
+	return h
+}
+
+func newTestServer() (http.Handler, *Resolver) {
+	users, library := NewMemoryUserStore(), NewMemoryLibraryStore()
+	r := &Resolver{
+		UserStore:    users,
+		LibraryStore: library,
+		Events:       NewUserEvents(),
+		Audit:        NewMemoryAuditStore(),
+	}
+	schema := NewExecutableSchema(Config{Resolvers: r, Directives: Directives()})
+	var h http.Handler = handler.GraphQL(schema, handler.ErrorPresenter(ErrorPresenter), handler.RecoverFunc(Recover))
+	h = LoaderMiddleware(users, library, 0, h)
+	return AuthMiddleware([]Identity{{Token: adminToken, Actor: Actor{Name: "root", Role: RoleAdmin}}}, h), r
+}
+
+type testResponse struct {
+	Data   json.RawMessage
+	Errors []struct {
+		Message    string
+		Path       []interface{}
+		Extensions map[string]interface{}
+	}
+}
+
+func execQuery(t *testing.T, h http.Handler, query string, vars map[string]interface{}) testResponse {
+	t.Helper()
+	return execAs(t, h, "", query, vars)
+}
+
+// execAs runs query with token as its bearer token, if token is set.
+func execAs(t *testing.T, h http.Handler, token string, query string, vars map[string]interface{}) testResponse {
+	t.Helper()
+	body, err := json.Marshal(map[string]interface{}{"query": query, "variables": vars})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/query", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	var resp testResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s: %v", w.Body, err)
+	}
+	return resp
+}
+
+func TestSchemaUsersConnection(t *testing.T) {
+	h := newTestHandler()
+	resp := execQuery(t, h, `mutation { createUsers(input: [{name: "a"}, {name: "b"}, {name: "c"}, {name: "d"}, {name: "e"}]) { succeeded } }`, nil)
+	if len(resp.Errors) > 0 {
+		t.Fatalf("createUsers: %+v", resp.Errors)
+	}
+
+	const query = `query($first: Int, $after: String, $last: Int, $before: String) {
+		usersConnection(first: $first, after: $after, last: $last, before: $before) {
+			edges { node { name } }
+			pageInfo { hasNextPage hasPreviousPage startCursor endCursor }
+		}
+	}`
+	type page struct {
+		names                  string
+		hasNext, hasPrevious   bool
+		startCursor, endCursor string
+	}
+	run := func(vars map[string]interface{}) page {
+		t.Helper()
+		resp := execQuery(t, h, query, vars)
+		if len(resp.Errors) > 0 {
+			t.Fatalf("usersConnection(%v): %+v", vars, resp.Errors)
+		}
+		var data struct {
+			UsersConnection struct {
+				Edges []struct {
+					Node struct{ Name string }
+				}
+				PageInfo struct {
+					HasNextPage, HasPreviousPage bool
+					StartCursor, EndCursor       string
+				}
+			}
+		}
+		if err := json.Unmarshal(resp.Data, &data); err != nil {
+			t.Fatal(err)
+		}
+		c := data.UsersConnection
+		p := page{hasNext: c.PageInfo.HasNextPage, hasPrevious: c.PageInfo.HasPreviousPage, startCursor: c.PageInfo.StartCursor, endCursor: c.PageInfo.EndCursor}
+		for _, e := range c.Edges {
+			p.names += e.Node.Name
+		}
+		return p
+	}
+	check := func(name string, got page, names string, hasNext, hasPrevious bool) {
+		t.Helper()
+		if got.names != names || got.hasNext != hasNext || got.hasPrevious != hasPrevious {
+			t.Errorf("%s: got %q next=%v previous=%v, want %q next=%v previous=%v",
+				name, got.names, got.hasNext, got.hasPrevious, names, hasNext, hasPrevious)
+		}
+	}
+
+	first := run(map[string]interface{}{"first": 2})
+	check("first", first, "ab", true, false)
+	next := run(map[string]interface{}{"first": 2, "after": first.endCursor})
+	check("after", next, "cd", true, true)
+	check("after to end", run(map[string]interface{}{"first": 5, "after": next.endCursor}), "e", false, true)
+
+	last := run(map[string]interface{}{"last": 2})
+	check("last", last, "de", false, true)
+	check("before", run(map[string]interface{}{"last": 2, "before": last.startCursor}), "bc", true, true)
+	check("before to start", run(map[string]interface{}{"last": 5, "before": next.startCursor}), "ab", true, false)
+	check("all", run(nil), "abcde", false, false)
+
+	resp = execQuery(t, h, `{ usersConnection(first: 1, last: 1) { edges { cursor } } }`, nil)
+	if len(resp.Errors) != 1 || resp.Errors[0].Extensions["code"] != string(CodeValidation) {
+		t.Errorf("first with last: %+v", resp.Errors)
+	}
+}
+
+func TestSchemaInputValidation(t *testing.T) {
+	long := strings.Repeat("x", 101)
+
+	tests := []struct {
+		name  string
+		query string
+		vars  map[string]interface{}
+		field []interface{}
+	}{
+		{"input field", `mutation { createUser(input: {name: " ann"}) { id } }`, nil,
+			[]interface{}{"input", "name"}},
+		{"input field from variables", `mutation($in: NewUser!) { createUser(input: $in) { id } }`,
+			map[string]interface{}{"in": map[string]interface{}{"name": long}},
+			[]interface{}{"input", "name"}},
+		{"list element", `mutation { createUsers(input: [{name: "ann"}, {name: ""}]) { succeeded } }`, nil,
+			[]interface{}{"input", float64(1), "name"}},
+		{"argument", `mutation { updateUser(id: "5e0000000000000000000000", name: "` + long + `") { id } }`, nil,
+			[]interface{}{"name"}},
+		{"nested patch", `mutation { updateUsers(filter: {}, patch: {name: "ann "}) { succeeded } }`, nil,
+			[]interface{}{"patch", "name"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := execQuery(t, newTestHandler(), tt.query, tt.vars)
+			if len(resp.Errors) != 1 {
+				t.Fatalf("got errors %+v, want one", resp.Errors)
+			}
+			ext := resp.Errors[0].Extensions
+			if ext["code"] != string(CodeValidation) || !reflect.DeepEqual(ext["field"], tt.field) {
+				t.Errorf("got extensions %v, want field %v", ext, tt.field)
+			}
+		})
+	}
+}
+
+func TestSchemaAdminMutationsPublish(t *testing.T) {
+	h, r := newTestServer()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := r.Events.Subscribe(ctx)
+
+	tests := []struct {
+		query string
+		want  []UserEvent
+	}{
+		{`mutation { createUser(input: {name: "a"}) { id } }`, []UserEvent{
+			{Kind: UserCreated, User: &supersimple.User{Name: "a"}},
+		}},
+		{`mutation { updateAllUsers(patch: {name: "c"}, confirm: "UPDATE ALL USERS") { affected } }`, []UserEvent{
+			{Kind: UserUpdated, User: &supersimple.User{Name: "c"}},
+		}},
+		{`mutation { createUser(input: {name: "b"}) { id } }`, []UserEvent{
+			{Kind: UserCreated, User: &supersimple.User{Name: "b"}},
+		}},
+		{`mutation { deleteAllUsers(confirm: "DELETE ALL USERS") { affected } }`, []UserEvent{
+			{Kind: UserDeleted, User: &supersimple.User{Name: "c"}},
+			{Kind: UserDeleted, User: &supersimple.User{Name: "b"}},
+		}},
+	}
+
+	for _, tt := range tests {
+		resp := execAs(t, h, adminToken, tt.query, nil)
+		if len(resp.Errors) > 0 {
+			t.Fatalf("%s: %+v", tt.query, resp.Errors)
+		}
+		for _, want := range tt.want {
+			select {
+			case evt := <-events:
+				if evt.Kind != want.Kind || evt.User.Name != want.User.Name {
+					t.Errorf("%s: got event %v for %q, want %v for %q", tt.query, evt.Kind, evt.User.Name, want.Kind, want.User.Name)
+				}
+			default:
+				t.Fatalf("%s: missing event %v for %q", tt.query, want.Kind, want.User.Name)
+			}
+		}
+	}
+	select {
+	case evt := <-events:
+		t.Errorf("unexpected event %v for %q", evt.Kind, evt.User.Name)
+	default:
+	}
+}
+
+func TestSchemaNameLookupIgnoresCase(t *testing.T) {
+	h := newTestHandler()
+	execQuery(t, h, `mutation { createUser(input: {name: "Ann"}) { id } }`, nil)
+
+	resp := execQuery(t, h, `mutation { createUser(input: {name: "ann"}) { id } }`, nil)
+	if len(resp.Errors) != 1 || resp.Errors[0].Extensions["code"] != string(CodeConflict) {
+		t.Errorf("creating a duplicate name: %+v", resp.Errors)
+	}
+
+	for _, query := range []string{
+		`{ oneUser(name: "ann") { name } }`,
+		`{ users(filter: {name: "ANN"}) { name } }`,
+	} {
+		resp := execQuery(t, h, query, nil)
+		if len(resp.Errors) > 0 || !strings.Contains(string(resp.Data), `"name":"Ann"`) {
+			t.Errorf("%s = %s, %+v", query, resp.Data, resp.Errors)
+		}
+	}
+}
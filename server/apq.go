@@ -0,0 +1,194 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	"log"
+	"sync"
+	"time"
+)
+
+// apqStats is published on the metrics listener as "apq". It counts hits
+// and misses overall and per tier, Redis errors, and queries refused by
+// TieredCache.
+var apqStats = expvar.NewMap("apq")
+
+// MemoryCache is an in-process persisted query cache. It holds at most
+// size queries, evicting the least recently used, and forgets each query
+// ttl after it was last added or found.
+type MemoryCache struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu sync.Mutex
+	// order holds *memoryEntry, most recently used first.
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryEntry struct {
+	hash    string
+	query   string
+	expires time.Time
+}
+
+func NewMemoryCache(size int, ttl time.Duration) *MemoryCache {
+	return &MemoryCache{size: size, ttl: ttl, now: time.Now, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (c *MemoryCache) Add(ctx context.Context, hash string, query string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := c.now().Add(c.ttl)
+	if e, ok := c.entries[hash]; ok {
+		entry := e.Value.(*memoryEntry)
+		entry.query, entry.expires = query, expires
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.entries[hash] = c.order.PushFront(&memoryEntry{hash: hash, query: query, expires: expires})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *MemoryCache) Get(ctx context.Context, hash string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[hash]
+	if !ok {
+		apqStats.Add("memory.misses", 1)
+		return "", false
+	}
+	entry := e.Value.(*memoryEntry)
+	now := c.now()
+	if now.After(entry.expires) {
+		c.remove(e)
+		apqStats.Add("memory.misses", 1)
+		return "", false
+	}
+	entry.expires = now.Add(c.ttl)
+	c.order.MoveToFront(e)
+	apqStats.Add("memory.hits", 1)
+	return entry.query, true
+}
+
+func (c *MemoryCache) remove(e *list.Element) {
+	c.order.Remove(e)
+	delete(c.entries, e.Value.(*memoryEntry).hash)
+}
+
+// redisRetryInterval is how long TieredCache uses memory alone after Redis
+// fails.
+const redisRetryInterval = 30 * time.Second
+
+// TieredCache checks memory before Redis, and keeps queries found in Redis
+// in memory. When Redis fails it degrades to memory alone, trying Redis
+// again after redisRetryInterval. A nil remote uses memory alone for good.
+//
+// Queries longer than maxQuerySize bytes, or whose SHA-256 is not their
+// hash, are not stored.
+type TieredCache struct {
+	memory       *MemoryCache
+	remote       remoteCache
+	maxQuerySize int
+	now          func() time.Time
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+// remoteCache is the shared tier of TieredCache, implemented by Cache.
+type remoteCache interface {
+	Ping() error
+	store(hash string, query string) error
+	lookup(hash string) (string, bool, error)
+}
+
+// NewTieredCache pings remote so that an unreachable Redis is logged on
+// startup rather than on the first request.
+func NewTieredCache(memory *MemoryCache, remote remoteCache, maxQuerySize int) *TieredCache {
+	c := &TieredCache{memory: memory, remote: remote, maxQuerySize: maxQuerySize, now: time.Now}
+	if remote != nil {
+		c.check(remote.Ping())
+	}
+	return c
+}
+
+func (c *TieredCache) Add(ctx context.Context, hash string, query string) {
+	if len(query) > c.maxQuerySize {
+		apqStats.Add("rejected.size", 1)
+		return
+	}
+	if sum := sha256.Sum256([]byte(query)); hex.EncodeToString(sum[:]) != hash {
+		apqStats.Add("rejected.hash", 1)
+		return
+	}
+
+	c.memory.Add(ctx, hash, query)
+	if c.available() {
+		c.check(c.remote.store(hash, query))
+	}
+}
+
+func (c *TieredCache) Get(ctx context.Context, hash string) (string, bool) {
+	query, ok := c.get(ctx, hash)
+	if ok {
+		apqStats.Add("hits", 1)
+	} else {
+		apqStats.Add("misses", 1)
+	}
+	return query, ok
+}
+
+func (c *TieredCache) get(ctx context.Context, hash string) (string, bool) {
+	if query, ok := c.memory.Get(ctx, hash); ok {
+		return query, true
+	}
+	if !c.available() {
+		return "", false
+	}
+
+	query, ok, err := c.remote.lookup(hash)
+	c.check(err)
+	if ok {
+		c.memory.Add(ctx, hash, query)
+	}
+	return query, ok
+}
+
+// available reports whether Redis should be tried.
+func (c *TieredCache) available() bool {
+	if c.remote == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now().After(c.downUntil)
+}
+
+// check degrades to memory alone if err is set, logging only the first of
+// consecutive failures and the recovery after them.
+func (c *TieredCache) check(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err == nil {
+		if !c.downUntil.IsZero() {
+			log.Printf("APQ redis cache reachable again")
+			c.downUntil = time.Time{}
+		}
+		return
+	}
+	if c.downUntil.IsZero() {
+		log.Printf("APQ redis cache unreachable, caching in memory only: %v", err)
+	}
+	c.downUntil = c.now().Add(redisRetryInterval)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// fakeRemote is a remoteCache that fails every call while err is set.
+type fakeRemote struct {
+	queries map[string]string
+	err     error
+	stores  int
+	lookups int
+}
+
+func newFakeRemote() *fakeRemote {
+	return &fakeRemote{queries: map[string]string{}}
+}
+
+func (r *fakeRemote) Ping() error { return r.err }
+
+func (r *fakeRemote) store(hash string, query string) error {
+	r.stores++
+	if r.err != nil {
+		return r.err
+	}
+	r.queries[hash] = query
+	return nil
+}
+
+func (r *fakeRemote) lookup(hash string) (string, bool, error) {
+	r.lookups++
+	if r.err != nil {
+		return "", false, r.err
+	}
+	q, ok := r.queries[hash]
+	return q, ok, nil
+}
+
+func hashOf(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// counter returns the current value of an apqStats counter.
+func counter(name string) int64 {
+	if v, ok := apqStats.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func newTestMemoryCache(size int, ttl time.Duration) (*MemoryCache, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c := NewMemoryCache(size, ttl)
+	c.now = clock.now
+	return c, clock
+}
+
+func newTestTieredCache(remote remoteCache) (*TieredCache, *fakeClock) {
+	memory, clock := newTestMemoryCache(10, time.Hour)
+	// The remote is pinged here rather than by NewTieredCache, so that an
+	// outage is timed by the fake clock.
+	c := NewTieredCache(memory, nil, 100)
+	c.remote = remote
+	c.now = clock.now
+	if remote != nil {
+		c.check(remote.Ping())
+	}
+	return c, clock
+}
+
+func wantGet(t *testing.T, c interface {
+	Get(context.Context, string) (string, bool)
+}, hash, want string) {
+	t.Helper()
+	got, ok := c.Get(context.Background(), hash)
+	if want == "" && ok {
+		t.Errorf("Get(%s) = %q, want a miss", hash, got)
+	}
+	if want != "" && (!ok || got != want) {
+		t.Errorf("Get(%s) = %q, %v, want %q", hash, got, ok, want)
+	}
+}
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestMemoryCache(2, time.Hour)
+
+	c.Add(ctx, "a", "qa")
+	c.Add(ctx, "b", "qb")
+	wantGet(t, c, "a", "qa")
+	c.Add(ctx, "c", "qc")
+
+	wantGet(t, c, "b", "")
+	wantGet(t, c, "a", "qa")
+	wantGet(t, c, "c", "qc")
+	if n := c.order.Len(); n != 2 || len(c.entries) != 2 {
+		t.Errorf("cache holds %d entries, want 2", n)
+	}
+}
+
+func TestMemoryCacheAddReplaces(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestMemoryCache(2, time.Hour)
+
+	c.Add(ctx, "a", "old")
+	c.Add(ctx, "b", "qb")
+	c.Add(ctx, "a", "new")
+	c.Add(ctx, "c", "qc")
+
+	wantGet(t, c, "a", "new")
+	wantGet(t, c, "b", "")
+	if n := c.order.Len(); n != 2 {
+		t.Errorf("cache holds %d entries, want 2", n)
+	}
+}
+
+func TestMemoryCacheTTLSlides(t *testing.T) {
+	ctx := context.Background()
+	c, clock := newTestMemoryCache(2, time.Minute)
+
+	c.Add(ctx, "a", "qa")
+	clock.advance(time.Minute)
+	wantGet(t, c, "a", "qa")
+
+	// The hit restarted the TTL, so the query outlives its first minute.
+	clock.advance(time.Minute)
+	wantGet(t, c, "a", "qa")
+
+	clock.advance(time.Minute + time.Nanosecond)
+	wantGet(t, c, "a", "")
+	if n := c.order.Len(); n != 0 || len(c.entries) != 0 {
+		t.Errorf("expired entry was kept")
+	}
+}
+
+func TestTieredCacheRejects(t *testing.T) {
+	ctx := context.Background()
+	remote := newFakeRemote()
+	c, _ := newTestTieredCache(remote)
+
+	long := "{ " + strings.Repeat("users { id } ", 10) + "}"
+	sizes, hashes := counter("rejected.size"), counter("rejected.hash")
+
+	c.Add(ctx, hashOf(long), long)
+	c.Add(ctx, hashOf("{ users { id } }"), "{ authors { id } }")
+
+	if got := counter("rejected.size") - sizes; got != 1 {
+		t.Errorf("rejected.size rose by %d, want 1", got)
+	}
+	if got := counter("rejected.hash") - hashes; got != 1 {
+		t.Errorf("rejected.hash rose by %d, want 1", got)
+	}
+	if remote.stores != 0 || c.memory.order.Len() != 0 {
+		t.Errorf("rejected queries were stored")
+	}
+	wantGet(t, c, hashOf("{ users { id } }"), "")
+
+	c.Add(ctx, hashOf("{ users { id } }"), "{ users { id } }")
+	wantGet(t, c, hashOf("{ users { id } }"), "{ users { id } }")
+	if remote.queries[hashOf("{ users { id } }")] != "{ users { id } }" {
+		t.Errorf("accepted query was not stored in the remote")
+	}
+}
+
+func TestTieredCacheWithoutRemote(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestTieredCache(nil)
+	query := "{ users { id } }"
+
+	hits, misses := counter("hits"), counter("misses")
+	wantGet(t, c, hashOf(query), "")
+	c.Add(ctx, hashOf(query), query)
+	wantGet(t, c, hashOf(query), query)
+
+	if counter("hits")-hits != 1 || counter("misses")-misses != 1 {
+		t.Errorf("hits and misses rose by %d and %d, want 1 and 1", counter("hits")-hits, counter("misses")-misses)
+	}
+}
+
+func TestTieredCacheReadsThroughRemote(t *testing.T) {
+	remote := newFakeRemote()
+	c, _ := newTestTieredCache(remote)
+	query := "{ users { id } }"
+	remote.queries[hashOf(query)] = query
+
+	wantGet(t, c, hashOf(query), query)
+	wantGet(t, c, hashOf(query), query)
+	if remote.lookups != 1 {
+		t.Errorf("remote was looked up %d times, want once", remote.lookups)
+	}
+}
+
+func TestTieredCacheDegradesAndRecovers(t *testing.T) {
+	ctx := context.Background()
+	remote := newFakeRemote()
+	remote.err = errors.New("connection refused")
+	c, clock := newTestTieredCache(remote)
+	query, other := "{ users { id } }", "{ authors { id } }"
+
+	// Down since the ping, so only memory is used.
+	c.Add(ctx, hashOf(query), query)
+	wantGet(t, c, hashOf(query), query)
+	wantGet(t, c, hashOf(other), "")
+	if remote.stores != 0 || remote.lookups != 0 {
+		t.Fatalf("remote was used while down")
+	}
+
+	// Still down just before the retry interval ends.
+	clock.advance(redisRetryInterval)
+	wantGet(t, c, hashOf(other), "")
+	if remote.lookups != 0 {
+		t.Fatalf("remote was retried early")
+	}
+
+	// Retried after it, and back in use once it succeeds.
+	remote.err = nil
+	remote.queries[hashOf(other)] = other
+	clock.advance(time.Nanosecond)
+	wantGet(t, c, hashOf(other), other)
+	if remote.lookups != 1 || !c.available() {
+		t.Fatalf("remote was not retried")
+	}
+
+	// A failed store degrades again.
+	remote.err = errors.New("connection reset")
+	third := "{ books { id } }"
+	c.Add(ctx, hashOf(third), third)
+	if c.available() {
+		t.Fatalf("remote still in use after failing")
+	}
+	wantGet(t, c, hashOf(third), third)
+	if remote.stores != 1 || remote.lookups != 1 {
+		t.Errorf("remote was used while down")
+	}
+}
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/allen-woods/supersimple"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config is everything the server can be configured with. Each setting is
+// read from, in increasing order of precedence:
+//
+//  1. the defaults in defaultConfig
+//  2. the YAML or JSON file named by -config or CONFIG_FILE
+//  3. its environment variable
+//  4. its command-line flag
+//
+// An empty variable or flag clears the settings in emptyClears, and is
+// ignored for every other setting, which cannot be empty.
+//
+// Run the server with -help for the flag and variable of every setting.
+type Config struct {
+	Port        string `json:"port" yaml:"port"`
+	MetricsAddr string `json:"metricsAddr" yaml:"metricsAddr"`
+	Store       string `json:"store" yaml:"store"`
+
+	Mongo struct {
+		URI         string   `json:"uri" yaml:"uri"`
+		Database    string   `json:"database" yaml:"database"`
+		MinPoolSize uint64   `json:"minPoolSize" yaml:"minPoolSize"`
+		MaxPoolSize uint64   `json:"maxPoolSize" yaml:"maxPoolSize"`
+		OpTimeout   Duration `json:"opTimeout" yaml:"opTimeout"`
+		// Collections renames collections, keyed by their default name.
+		Collections collectionNames `json:"collections" yaml:"collections"`
+		// Indexes are created on startup along with the ones the stores
+		// rely on. They can only be set in the config file.
+		Indexes []IndexConfig `json:"indexes" yaml:"indexes"`
+	} `json:"mongo" yaml:"mongo"`
+
+	Redis struct {
+		// Addrs is one address, or the seed addresses of a cluster, or of
+		// the Sentinels when MasterName is set.
+		Addrs      addressList `json:"addrs" yaml:"addrs"`
+		MasterName string      `json:"masterName" yaml:"masterName"`
+		DB         int         `json:"db" yaml:"db"`
+		Password   string      `json:"password" yaml:"password"`
+		TLS        struct {
+			Enabled            bool   `json:"enabled" yaml:"enabled"`
+			ServerName         string `json:"serverName" yaml:"serverName"`
+			CAFile             string `json:"caFile" yaml:"caFile"`
+			InsecureSkipVerify bool   `json:"insecureSkipVerify" yaml:"insecureSkipVerify"`
+		} `json:"tls" yaml:"tls"`
+		APQTTL Duration `json:"apqTTL" yaml:"apqTTL"`
+	} `json:"redis" yaml:"redis"`
+
+	// APQCache bounds the in-memory persisted query cache in front of
+	// Redis. MaxQuerySize, in bytes, applies to both.
+	APQCache struct {
+		Size         int      `json:"size" yaml:"size"`
+		TTL          Duration `json:"ttl" yaml:"ttl"`
+		MaxQuerySize int      `json:"maxQuerySize" yaml:"maxQuerySize"`
+	} `json:"apqCache" yaml:"apqCache"`
+
+	AdminToken string `json:"adminToken" yaml:"adminToken"`
+	// Actors authenticate with their bearer token, which names them in
+	// the audit log. They can only be set in the config file, keeping
+	// their tokens out of the environment and the command line.
+	Actors []ActorConfig `json:"actors" yaml:"actors"`
+
+	LoaderWait       Duration `json:"loaderWait" yaml:"loaderWait"`
+	DeletedRetention Duration `json:"deletedRetention" yaml:"deletedRetention"`
+}
+
+// ActorConfig is an actor known by its bearer token. Role is "user" or
+// "admin".
+type ActorConfig struct {
+	Name  string `json:"name" yaml:"name"`
+	Role  string `json:"role" yaml:"role"`
+	Token string `json:"token" yaml:"token"`
+}
+
+// IndexConfig is an extra index on one of the collections the stores use.
+// Keys are field names, in order, each prefixed with "-" to index it in
+// descending order.
+type IndexConfig struct {
+	Collection string   `json:"collection" yaml:"collection"`
+	Name       string   `json:"name" yaml:"name"`
+	Keys       []string `json:"keys" yaml:"keys"`
+	Unique     bool     `json:"unique" yaml:"unique"`
+}
+
+func defaultConfig() *Config {
+	c := &Config{Port: "8080", MetricsAddr: "localhost:9090", Store: "mongo"}
+	c.Mongo.URI = "mongodb://localhost:27017"
+	c.Mongo.Database = "simple"
+	c.Mongo.MaxPoolSize = 100
+	c.Mongo.OpTimeout = Duration(supersimple.DefaultOpTimeout)
+	c.Redis.Addrs = addressList{"localhost:6379"}
+	c.Redis.APQTTL = Duration(24 * time.Hour)
+	c.APQCache.Size = 1000
+	c.APQCache.TTL = Duration(time.Hour)
+	c.APQCache.MaxQuerySize = 64 << 10
+	c.LoaderWait = Duration(supersimple.DefaultLoaderWait)
+	c.DeletedRetention = Duration(supersimple.DefaultRetention)
+	return c
+}
+
+// setting ties a Config field to its flag and environment variable.
+type setting struct {
+	flag, env, usage string
+	value            func(c *Config) flag.Value
+}
+
+var settings = []setting{
+	{"port", "PORT", "HTTP port to listen on", func(c *Config) flag.Value { return (*stringValue)(&c.Port) }},
+	{"metrics-addr", "METRICS_ADDR", "address serving /debug/vars; empty disables it", func(c *Config) flag.Value { return (*stringValue)(&c.MetricsAddr) }},
+	{"store", "STORE", `"mongo", or "memory" to run without a database`, func(c *Config) flag.Value { return (*stringValue)(&c.Store) }},
+	{"mongo-uri", "MONGO_URI", "MongoDB connection string", func(c *Config) flag.Value { return (*stringValue)(&c.Mongo.URI) }},
+	{"mongo-database", "MONGO_DATABASE", "MongoDB database name", func(c *Config) flag.Value { return (*stringValue)(&c.Mongo.Database) }},
+	{"mongo-min-pool-size", "MONGO_MIN_POOL_SIZE", "MongoDB connections kept open while idle", func(c *Config) flag.Value { return (*uint64Value)(&c.Mongo.MinPoolSize) }},
+	{"mongo-pool-size", "MONGO_POOL_SIZE", "maximum pooled MongoDB connections", func(c *Config) flag.Value { return (*uint64Value)(&c.Mongo.MaxPoolSize) }},
+	{"mongo-op-timeout", "MONGO_OP_TIMEOUT", "timeout of each MongoDB operation", func(c *Config) flag.Value { return &c.Mongo.OpTimeout }},
+	{"mongo-collections", "MONGO_COLLECTIONS", "collection renames, such as users=staging_users,audit=staging_audit", func(c *Config) flag.Value { return &c.Mongo.Collections }},
+	{"redis-addr", "REDIS_ADDR", "Redis address for the APQ cache, or comma-separated cluster or Sentinel addresses; empty caches in memory only", func(c *Config) flag.Value { return &c.Redis.Addrs }},
+	{"redis-master-name", "REDIS_MASTER_NAME", "Sentinel master name; enables Sentinel mode", func(c *Config) flag.Value { return (*stringValue)(&c.Redis.MasterName) }},
+	{"redis-db", "REDIS_DB", "Redis database index; not available in cluster mode", func(c *Config) flag.Value { return (*intValue)(&c.Redis.DB) }},
+	{"redis-password", "REDIS_PASSWORD", "Redis password", func(c *Config) flag.Value { return (*stringValue)(&c.Redis.Password) }},
+	{"redis-tls", "REDIS_TLS", "connect to Redis over TLS", func(c *Config) flag.Value { return (*boolValue)(&c.Redis.TLS.Enabled) }},
+	{"redis-tls-server-name", "REDIS_TLS_SERVER_NAME", "server name to verify, if not the host of the address", func(c *Config) flag.Value { return (*stringValue)(&c.Redis.TLS.ServerName) }},
+	{"redis-tls-ca-file", "REDIS_TLS_CA_FILE", "PEM file of the CAs to trust instead of the system pool", func(c *Config) flag.Value { return (*stringValue)(&c.Redis.TLS.CAFile) }},
+	{"redis-tls-insecure", "REDIS_TLS_INSECURE", "skip verifying the Redis certificate", func(c *Config) flag.Value { return (*boolValue)(&c.Redis.TLS.InsecureSkipVerify) }},
+	{"apq-ttl", "APQ_TTL", "how long persisted queries are cached in Redis after their last use", func(c *Config) flag.Value { return &c.Redis.APQTTL }},
+	{"apq-cache-size", "APQ_CACHE_SIZE", "most persisted queries cached in memory", func(c *Config) flag.Value { return (*intValue)(&c.APQCache.Size) }},
+	{"apq-cache-ttl", "APQ_CACHE_TTL", "how long persisted queries are cached in memory after their last use", func(c *Config) flag.Value { return &c.APQCache.TTL }},
+	{"apq-max-query-size", "APQ_MAX_QUERY_SIZE", "largest persisted query cached, in bytes", func(c *Config) flag.Value { return (*intValue)(&c.APQCache.MaxQuerySize) }},
+	{"admin-token", "ADMIN_TOKEN", "bearer token of the \"admin\" actor; empty disables it", func(c *Config) flag.Value { return (*stringValue)(&c.AdminToken) }},
+	{"loader-wait", "LOADER_WAIT", "how long loaders collect keys into one batch", func(c *Config) flag.Value { return &c.LoaderWait }},
+	{"deleted-retention", "DELETED_RETENTION", "how long soft-deleted users are kept", func(c *Config) flag.Value { return &c.DeletedRetention }},
+}
+
+// emptyClears holds the flags of the settings that may be cleared.
+var emptyClears = map[string]bool{
+	"metrics-addr":          true,
+	"mongo-collections":     true,
+	"redis-addr":            true,
+	"redis-master-name":     true,
+	"redis-password":        true,
+	"redis-tls-server-name": true,
+	"redis-tls-ca-file":     true,
+	"admin-token":           true,
+}
+
+// ignores reports whether v leaves the setting as it is.
+func (s setting) ignores(v string) bool {
+	return v == "" && !emptyClears[s.flag]
+}
+
+// loadConfig builds the Config from args, the command line without the
+// program name, and lookupEnv, which tells an unset variable apart from
+// one set to "" so that the latter can clear its setting. It returns the
+// arguments left after the flags and whether -print-config was given.
+func loadConfig(args []string, lookupEnv func(string) (string, bool)) (c *Config, rest []string, printConfig bool, err error) {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	file := fs.String("config", "", "YAML or JSON config file (env CONFIG_FILE)")
+	fs.BoolVar(&printConfig, "print-config", false, "print the effective config, with secrets redacted, and exit")
+
+	// Flags are parsed first but applied last, so they are recorded as
+	// raw strings until the file and environment have been read.
+	flags := map[string]string{}
+	for _, s := range settings {
+		fs.Var(&flagValue{s, flags}, s.flag, fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, false, err
+	}
+
+	c = defaultConfig()
+
+	if *file == "" {
+		*file, _ = lookupEnv("CONFIG_FILE")
+	}
+	if *file != "" {
+		if err := c.readFile(*file); err != nil {
+			return nil, nil, false, err
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := lookupEnv(s.env); ok && !s.ignores(v) {
+			if err := s.value(c).Set(v); err != nil {
+				return nil, nil, false, errors.Errorf("invalid %s %q: %v", s.env, v, err)
+			}
+		}
+	}
+	for _, s := range settings {
+		if v, ok := flags[s.flag]; ok {
+			s.value(c).Set(v)
+		}
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, nil, false, err
+	}
+	return c, fs.Args(), printConfig, nil
+}
+
+// readFile overlays the settings in the named file onto c. The format
+// follows the extension; anything but .json is read as YAML.
+func (c *Config) readFile(name string) error {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return errors.Wrap(err, "cannot read config file")
+	}
+
+	if strings.EqualFold(filepath.Ext(name), ".json") {
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.DisallowUnknownFields()
+		err = d.Decode(c)
+	} else {
+		err = yaml.UnmarshalStrict(b, c)
+	}
+	return errors.Wrapf(err, "invalid config file %s", name)
+}
+
+// validate reports every invalid setting at once.
+func (c *Config) validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	port, err := strconv.Atoi(c.Port)
+	check(err == nil && port > 0 && port < 65536, "port must be between 1 and 65535")
+	check(c.Store == "mongo" || c.Store == "memory", `store must be "mongo" or "memory"`)
+	if c.Store == "mongo" {
+		check(strings.HasPrefix(c.Mongo.URI, "mongodb://") || strings.HasPrefix(c.Mongo.URI, "mongodb+srv://"),
+			"mongo.uri must start with mongodb:// or mongodb+srv://")
+		check(c.Mongo.Database != "", "mongo.database must be set")
+		check(c.Mongo.MaxPoolSize > 0, "mongo.maxPoolSize must be positive")
+		check(c.Mongo.MinPoolSize <= c.Mongo.MaxPoolSize, "mongo.minPoolSize must not exceed mongo.maxPoolSize")
+		check(c.Mongo.OpTimeout > 0, "mongo.opTimeout must be positive")
+	}
+	for name, renamed := range c.Mongo.Collections {
+		check(knownCollections[name], "mongo.collections: unknown collection %q", name)
+		check(renamed != "", "mongo.collections: %s must not be renamed to nothing", name)
+	}
+	indexes := map[string]bool{}
+	for _, spec := range supersimple.DefaultIndexes {
+		indexes[spec.Collection+"."+spec.Name] = true
+	}
+	for i, index := range c.Mongo.Indexes {
+		check(knownCollections[index.Collection], "mongo.indexes[%d]: unknown collection %q", i, index.Collection)
+		check(index.Name != "", "mongo.indexes[%d].name must be set", i)
+		check(!indexes[index.Collection+"."+index.Name], "mongo.indexes[%d]: %s already has an index named %q", i, index.Collection, index.Name)
+		indexes[index.Collection+"."+index.Name] = true
+		check(len(index.Keys) > 0, "mongo.indexes[%d].keys must be set", i)
+		for _, key := range index.Keys {
+			check(strings.TrimPrefix(key, "-") != "", "mongo.indexes[%d].keys must not be empty", i)
+		}
+	}
+	check(c.Redis.DB >= 0, "redis.db must not be negative")
+	check(c.Redis.DB == 0 || c.Redis.MasterName != "" || len(c.Redis.Addrs) == 1, "redis.db must be 0 in cluster mode")
+	check(c.Redis.APQTTL > 0, "redis.apqTTL must be positive")
+	tokens := map[string]bool{c.AdminToken: c.AdminToken != ""}
+	for i, a := range c.Actors {
+		check(a.Name != "", "actors[%d].name must be set", i)
+		check(a.Role == string(supersimple.RoleUser) || a.Role == string(supersimple.RoleAdmin), `actors[%d].role must be "user" or "admin"`, i)
+		check(a.Token != "", "actors[%d].token must be set", i)
+		check(!tokens[a.Token], "actors[%d].token is already used", i)
+		tokens[a.Token] = true
+	}
+	check(c.APQCache.Size > 0, "apqCache.size must be positive")
+	check(c.APQCache.TTL > 0, "apqCache.ttl must be positive")
+	check(c.APQCache.MaxQuerySize > 0, "apqCache.maxQuerySize must be positive")
+	check(c.LoaderWait >= 0, "loaderWait must not be negative")
+	check(c.DeletedRetention > 0, "deletedRetention must be positive")
+
+	if len(problems) > 0 {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (c *Config) mongoOptions() supersimple.MongoOptions {
+	return supersimple.MongoOptions{
+		URI:         c.Mongo.URI,
+		Database:    c.Mongo.Database,
+		MinPoolSize: c.Mongo.MinPoolSize,
+		MaxPoolSize: c.Mongo.MaxPoolSize,
+		OpTimeout:   time.Duration(c.Mongo.OpTimeout),
+		Collections: c.Mongo.Collections,
+	}
+}
+
+// indexes returns the indexes to ensure on startup: the ones the stores
+// rely on, followed by those in the config.
+func (c *Config) indexes() []supersimple.IndexSpec {
+	specs := append([]supersimple.IndexSpec(nil), supersimple.DefaultIndexes...)
+	for _, index := range c.Mongo.Indexes {
+		keys := bson.D{}
+		for _, key := range index.Keys {
+			if strings.HasPrefix(key, "-") {
+				keys = append(keys, bson.E{Key: key[1:], Value: -1})
+			} else {
+				keys = append(keys, bson.E{Key: key, Value: 1})
+			}
+		}
+		specs = append(specs, supersimple.IndexSpec{Collection: index.Collection, Name: index.Name, Keys: keys, Unique: index.Unique})
+	}
+	return specs
+}
+
+// identities returns the actors that authenticate with a bearer token,
+// including the admin of adminToken.
+func (c *Config) identities() []supersimple.Identity {
+	var ids []supersimple.Identity
+	if c.AdminToken != "" {
+		ids = append(ids, supersimple.Identity{Token: c.AdminToken, Actor: supersimple.Actor{Name: "admin", Role: supersimple.RoleAdmin}})
+	}
+	for _, a := range c.Actors {
+		ids = append(ids, supersimple.Identity{Token: a.Token, Actor: supersimple.Actor{Name: a.Name, Role: supersimple.Role(a.Role)}})
+	}
+	return ids
+}
+
+// redisOptions returns the options of the APQ cache client. It fails if
+// the CA file cannot be read.
+func (c *Config) redisOptions() (*redis.UniversalOptions, error) {
+	opts := &redis.UniversalOptions{
+		Addrs:      c.Redis.Addrs,
+		MasterName: c.Redis.MasterName,
+		DB:         c.Redis.DB,
+		Password:   c.Redis.Password,
+	}
+	if !c.Redis.TLS.Enabled {
+		return opts, nil
+	}
+
+	opts.TLSConfig = &tls.Config{
+		ServerName:         c.Redis.TLS.ServerName,
+		InsecureSkipVerify: c.Redis.TLS.InsecureSkipVerify,
+	}
+	if c.Redis.TLS.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.Redis.TLS.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot read Redis CA file")
+		}
+		opts.TLSConfig.RootCAs = x509.NewCertPool()
+		if !opts.TLSConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates in Redis CA file %s", c.Redis.TLS.CAFile)
+		}
+	}
+	return opts, nil
+}
+
+// redacted is the setting value printed in place of a secret.
+const redacted = "REDACTED"
+
+// Redacted returns a copy of c that is safe to print. Secrets are replaced
+// unless they are empty, and only the password of the Mongo URI is.
+func (c *Config) Redacted() *Config {
+	r := *c
+	if r.Redis.Password != "" {
+		r.Redis.Password = redacted
+	}
+	if r.AdminToken != "" {
+		r.AdminToken = redacted
+	}
+	r.Actors = make([]ActorConfig, len(c.Actors))
+	for i, a := range c.Actors {
+		a.Token = redacted
+		r.Actors[i] = a
+	}
+	if u, err := url.Parse(r.Mongo.URI); err != nil {
+		r.Mongo.URI = redacted
+	} else if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+		r.Mongo.URI = u.String()
+	}
+	return &r
+}
+
+// Duration is a time.Duration written as a string such as "24h", in
+// config files, flags and environment variables alike.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return d.Set(s)
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return d.Set(s)
+}
+
+// flagValue checks a flag against its setting and records it in flags.
+type flagValue struct {
+	setting setting
+	flags   map[string]string
+}
+
+func (v *flagValue) String() string {
+	if v.setting.value == nil {
+		return ""
+	}
+	return v.setting.value(defaultConfig()).String()
+}
+
+func (v *flagValue) Set(s string) error {
+	if v.setting.ignores(s) {
+		return nil
+	}
+	if err := v.setting.value(defaultConfig()).Set(s); err != nil {
+		return err
+	}
+	v.flags[v.setting.flag] = s
+	return nil
+}
+
+// IsBoolFlag lets boolean settings be given as flags without a value.
+func (v *flagValue) IsBoolFlag() bool {
+	b, ok := v.setting.value(defaultConfig()).(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// knownCollections are the collection names the stores use.
+var knownCollections = map[string]bool{
+	"users": true, "authors": true, "books": true, "audit": true, "migrations": true,
+}
+
+// collectionNames is written as name=renamed pairs separated by commas in
+// flags and environment variables, and as a mapping in config files.
+type collectionNames map[string]string
+
+func (n collectionNames) String() string {
+	pairs := make([]string, 0, len(n))
+	for name, renamed := range n {
+		pairs = append(pairs, name+"="+renamed)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (n *collectionNames) Set(s string) error {
+	names := collectionNames{}
+	if s == "" {
+		*n = names
+		return nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		i := strings.Index(pair, "=")
+		if i < 0 {
+			return errors.Errorf("%q is not name=renamed", pair)
+		}
+		names[strings.TrimSpace(pair[:i])] = strings.TrimSpace(pair[i+1:])
+	}
+	*n = names
+	return nil
+}
+
+// addressList is written as comma-separated addresses in flags and
+// environment variables, and as a list in config files.
+type addressList []string
+
+func (l addressList) String() string { return strings.Join(l, ",") }
+
+func (l *addressList) Set(s string) error {
+	var addrs addressList
+	for _, addr := range strings.Split(s, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	*l = addrs
+	return nil
+}
+
+type stringValue string
+
+func (v *stringValue) String() string     { return string(*v) }
+func (v *stringValue) Set(s string) error { *v = stringValue(s); return nil }
+
+type intValue int
+
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*v = intValue(n)
+	return nil
+}
+
+type boolValue bool
+
+func (v *boolValue) String() string { return strconv.FormatBool(bool(*v)) }
+
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*v = boolValue(b)
+	return nil
+}
+
+func (v *boolValue) IsBoolFlag() bool { return true }
+
+type uint64Value uint64
+
+func (v *uint64Value) String() string { return strconv.FormatUint(uint64(*v), 10) }
+
+func (v *uint64Value) Set(s string) error {
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*v = uint64Value(n)
+	return nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/allen-woods/supersimple"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// env returns a lookupEnv over vars.
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := writeFile(t, dir, "config.yml", `
+port: "1001"
+mongo:
+  database: file
+  opTimeout: 1s
+redis:
+  apqTTL: 1h
+loaderWait: 1ms
+`)
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want func(c *Config)
+	}{
+		{"defaults", nil, nil, func(c *Config) {}},
+		{"file over defaults", []string{"-config", file}, nil, func(c *Config) {
+			c.Port = "1001"
+			c.Mongo.Database = "file"
+			c.Mongo.OpTimeout = Duration(time.Second)
+			c.Redis.APQTTL = Duration(time.Hour)
+			c.LoaderWait = Duration(time.Millisecond)
+		}},
+		{"file from env", nil, map[string]string{"CONFIG_FILE": file}, func(c *Config) {
+			c.Port = "1001"
+			c.Mongo.Database = "file"
+			c.Mongo.OpTimeout = Duration(time.Second)
+			c.Redis.APQTTL = Duration(time.Hour)
+			c.LoaderWait = Duration(time.Millisecond)
+		}},
+		{"env over file", []string{"-config", file}, map[string]string{
+			"PORT":             "1002",
+			"MONGO_DATABASE":   "env",
+			"MONGO_OP_TIMEOUT": "2s",
+		}, func(c *Config) {
+			c.Port = "1002"
+			c.Mongo.Database = "env"
+			c.Mongo.OpTimeout = Duration(2 * time.Second)
+			c.Redis.APQTTL = Duration(time.Hour)
+			c.LoaderWait = Duration(time.Millisecond)
+		}},
+		{"flags over env", []string{"-config", file, "-port", "1003", "-mongo-op-timeout", "3s", "-mongo-min-pool-size", "4"}, map[string]string{
+			"PORT":             "1002",
+			"MONGO_DATABASE":   "env",
+			"MONGO_OP_TIMEOUT": "2s",
+		}, func(c *Config) {
+			c.Port = "1003"
+			c.Mongo.Database = "env"
+			c.Mongo.MinPoolSize = 4
+			c.Mongo.OpTimeout = Duration(3 * time.Second)
+			c.Redis.APQTTL = Duration(time.Hour)
+			c.LoaderWait = Duration(time.Millisecond)
+		}},
+		{"empty env clears", nil, map[string]string{"REDIS_ADDR": "", "ADMIN_TOKEN": "", "METRICS_ADDR": ""}, func(c *Config) {
+			c.Redis.Addrs = nil
+			c.MetricsAddr = ""
+		}},
+		{"empty flag clears env", []string{"-admin-token", ""}, map[string]string{"ADMIN_TOKEN": "secret"}, func(c *Config) {}},
+		{"empty env ignored for required settings", []string{"-config", file}, map[string]string{
+			"PORT":             "",
+			"MONGO_DATABASE":   "",
+			"MONGO_OP_TIMEOUT": "",
+			"MONGO_POOL_SIZE":  "",
+			"REDIS_TLS":        "",
+		}, func(c *Config) {
+			c.Port = "1001"
+			c.Mongo.Database = "file"
+			c.Mongo.OpTimeout = Duration(time.Second)
+			c.Redis.APQTTL = Duration(time.Hour)
+			c.LoaderWait = Duration(time.Millisecond)
+		}},
+		{"empty flag ignored for required settings", []string{"-port", "", "-apq-ttl", ""}, map[string]string{"PORT": "1002"}, func(c *Config) {
+			c.Port = "1002"
+		}},
+		{"lists", []string{"-redis-addr", "a:1, b:2", "-mongo-collections", "users=u,audit=a"}, nil, func(c *Config) {
+			c.Redis.Addrs = addressList{"a:1", "b:2"}
+			c.Mongo.Collections = collectionNames{"users": "u", "audit": "a"}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, _, err := loadConfig(tt.args, env(tt.env))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := defaultConfig()
+			tt.want(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("loadConfig() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigJSON(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := writeFile(t, dir, "config.json", `{"store": "memory", "apqCache": {"ttl": "5m"}}`)
+
+	got, _, _, err := loadConfig([]string{"-config", file}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Store != "memory" || got.APQCache.TTL != Duration(5*time.Minute) {
+		t.Errorf("loadConfig() = %+v", got)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		file string
+	}{
+		{"bad flag", []string{"-apq-ttl", "soon"}, nil, ""},
+		{"bad env", nil, map[string]string{"MONGO_POOL_SIZE": "many"}, ""},
+		{"unknown file key", nil, nil, "bogus: 1\n"},
+		{"invalid", []string{"-port", "0"}, nil, ""},
+		{"min pool over max", []string{"-mongo-min-pool-size", "10", "-mongo-pool-size", "5"}, nil, ""},
+		{"duplicate token", []string{"-admin-token", "t"}, nil, "actors: [{name: a, role: user, token: t}]\n"},
+		{"index on unknown collection", nil, nil, "mongo: {indexes: [{collection: people, name: a, keys: [a]}]}\n"},
+		{"index named like a default", nil, nil, "mongo: {indexes: [{collection: users, name: name_unique, keys: [a]}]}\n"},
+		{"index without keys", nil, nil, "mongo: {indexes: [{collection: users, name: a}]}\n"},
+		{"index on an empty key", nil, nil, "mongo: {indexes: [{collection: users, name: a, keys: [\"-\"]}]}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeFile(t, dir, tt.name+".yml", tt.file)}, args...)
+			}
+			if _, _, _, err := loadConfig(args, env(tt.env)); err == nil {
+				t.Error("loadConfig() succeeded")
+			}
+		})
+	}
+}
+
+func TestConfigIndexes(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := writeFile(t, dir, "config.yml", `
+mongo:
+  indexes:
+    - {collection: audit, name: actor, keys: [actor, -timestamp]}
+    - {collection: users, name: email, keys: [email], unique: true}
+`)
+
+	c, _, _, err := loadConfig([]string{"-config", file}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append([]supersimple.IndexSpec(nil), supersimple.DefaultIndexes...)
+	want = append(want,
+		supersimple.IndexSpec{Collection: "audit", Name: "actor", Keys: bson.D{{Key: "actor", Value: 1}, {Key: "timestamp", Value: -1}}},
+		supersimple.IndexSpec{Collection: "users", Name: "email", Keys: bson.D{{Key: "email", Value: 1}}, Unique: true},
+	)
+	if got := c.indexes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("indexes() = %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadConfigRest(t *testing.T) {
+	_, rest, printConfig, err := loadConfig([]string{"-print-config", "migrate", "list"}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !printConfig || !reflect.DeepEqual(rest, []string{"migrate", "list"}) {
+		t.Errorf("loadConfig() = %v, %v", rest, printConfig)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	c := defaultConfig()
+	c.Mongo.URI = "mongodb://bob:hunter2@db:27017/simple"
+	c.Redis.Password = "hunter2"
+	c.AdminToken = "hunter2"
+	c.Actors = []ActorConfig{{Name: "alice", Role: "admin", Token: "hunter2"}}
+
+	r := c.Redacted()
+	if r.Mongo.URI != "mongodb://bob:"+redacted+"@db:27017/simple" || r.Redis.Password != redacted ||
+		r.AdminToken != redacted || r.Actors[0].Token != redacted {
+		t.Errorf("Redacted() = %+v", r)
+	}
+	if c.Actors[0].Token != "hunter2" {
+		t.Error("Redacted() changed the original")
+	}
+}
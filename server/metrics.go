@@ -0,0 +1,34 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+)
+
+// metricsVars are the expvars served by metricsHandler. The standard
+// cmdline and memstats are left out, as cmdline holds any secret given as
+// a flag.
+var metricsVars = []string{"dataloader", "apq"}
+
+// metricsHandler serves metricsVars in the format of expvar.Handler. It is
+// mounted on the metrics listener only, never on the public one.
+func metricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, "{")
+		first := true
+		for _, name := range metricsVars {
+			v := expvar.Get(name)
+			if v == nil {
+				continue
+			}
+			if !first {
+				fmt.Fprint(w, ",")
+			}
+			first = false
+			fmt.Fprintf(w, "\n%q: %s", name, v)
+		}
+		fmt.Fprint(w, "\n}\n")
+	})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/allen-woods/supersimple"
+)
+
+const migrateUsage = `usage: server [flags] migrate list
+       server [flags] migrate apply [version]     apply pending migrations up to version, or all
+       server [flags] migrate rollback [version]  roll back to version, or undo the newest`
+
+// migrateCommand runs the migrate subcommand and returns its exit status.
+func migrateCommand(cfg *Config, args []string) int {
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	switch args[0] {
+	case "list":
+		if len(args) > 1 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+	case "apply", "rollback":
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	target := -1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+		target = n
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), supersimple.DefaultMigrationLease)
+	defer cancel()
+
+	db, err := supersimple.NewMongo(ctx, cfg.mongoOptions())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cannot connect to MongoDB:", err)
+		return 1
+	}
+	defer db.Disconnect(context.Background())
+
+	migrator, err := supersimple.NewMigrator(db, supersimple.Migrations)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var done []int
+	switch args[0] {
+	case "list":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tAPPLIED\tDESCRIPTION")
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, applied, s.Description)
+		}
+		w.Flush()
+		return 0
+	case "apply":
+		if target < 0 {
+			target = 0
+		}
+		done, err = migrator.Up(ctx, target)
+		for _, v := range done {
+			fmt.Println("applied migration", v)
+		}
+	case "rollback":
+		if target < 0 {
+			target = newestApplied(status) - 1
+		}
+		if target < 0 {
+			fmt.Println("no migrations to roll back")
+			return 0
+		}
+		done, err = migrator.Down(ctx, target)
+		for _, v := range done {
+			fmt.Println("rolled back migration", v)
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(done) == 0 {
+		fmt.Println("nothing to do")
+	}
+	return 0
+}
+
+// newestApplied returns the highest applied version, or 0 if none is.
+func newestApplied(status []supersimple.MigrationStatus) int {
+	newest := 0
+	for _, s := range status {
+		if s.AppliedAt != nil && s.Version > newest {
+			newest = s.Version
+		}
+	}
+	return newest
+}
@@ -2,14 +2,18 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/99designs/gqlgen/handler"
 	"github.com/allen-woods/supersimple"
 	"github.com/go-redis/redis"
+	yaml "gopkg.in/yaml.v2"
 
 	//"github.com/gorilla/sessions"
 	"github.com/pkg/errors"
@@ -22,53 +26,215 @@ type Cache struct {
 }
 
 const apqPrefix = "apq:"
-const defaultPort = "8080"
-const redisAddr = "localhost:6379"
-const redisPass = ""
 
-func NewCache(redisAddress string, password string, ttl time.Duration) (*Cache, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr: redisAddress,
-	})
-
-	err := client.Ping().Err()
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
+// NewCache returns a cache over Redis. opts selects a single node, a
+// cluster when it has several Addrs, or Sentinel failover when it has a
+// MasterName. Connections are made on first use; Ping checks that Redis
+// is reachable.
+func NewCache(opts *redis.UniversalOptions, ttl time.Duration) *Cache {
+	return &Cache{client: redis.NewUniversalClient(opts), ttl: ttl}
+}
 
-	return &Cache{client: client, ttl: ttl}, nil
+func (c *Cache) Ping() error {
+	return errors.WithStack(c.client.Ping().Err())
 }
 
 func (c *Cache) Add(ctx context.Context, hash string, query string) {
-	c.client.Set(apqPrefix+hash, query, c.ttl)
+	c.store(hash, query)
 }
 
 func (c *Cache) Get(ctx context.Context, hash string) (string, bool) {
-	s, err := c.client.Get(apqPrefix + hash).Result()
+	s, ok, _ := c.lookup(hash)
+	return s, ok
+}
+
+// store and lookup are Add and Get with the Redis errors reported.
+func (c *Cache) store(hash string, query string) error {
+	err := c.client.Set(apqPrefix+hash, query, c.ttl).Err()
 	if err != nil {
-		return "", false
+		apqStats.Add("redis.errors", 1)
 	}
-	return s, true
+	return err
 }
 
-func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = defaultPort
+// lookup restarts the TTL of the query it finds, in the same round trip.
+func (c *Cache) lookup(hash string) (string, bool, error) {
+	var get *redis.StringCmd
+	_, err := c.client.Pipelined(func(p redis.Pipeliner) error {
+		get = p.Get(apqPrefix + hash)
+		p.Expire(apqPrefix+hash, c.ttl)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		apqStats.Add("redis.errors", 1)
+		return "", false, err
 	}
+	if get.Err() == redis.Nil {
+		apqStats.Add("redis.misses", 1)
+		return "", false, nil
+	}
+	apqStats.Add("redis.hits", 1)
+	return get.Val(), true, nil
+}
 
-	cache, err := NewCache(redisAddr, redisPass, 24*time.Hour)
+func main() {
+	cfg, args, printConfig, err := loadConfig(os.Args[1:], os.LookupEnv)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
 	if err != nil {
-		log.Fatalf("cannot create APQ redis cache: %v", err)
+		log.Fatal(err)
+	}
+	if printConfig {
+		out, err := yaml.Marshal(cfg.Redacted())
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(out)
+		return
+	}
+
+	// "server migrate ..." manages migrations instead of serving.
+	if len(args) > 0 && args[0] == "migrate" {
+		os.Exit(migrateCommand(cfg, args[1:]))
+	}
+	if len(args) > 0 {
+		log.Fatalf("unexpected arguments %q", args)
 	}
 
-	http.Handle("/", handler.Playground("GraphQL playground", "/query"))
-	http.Handle("/query", handler.GraphQL(
-		supersimple.NewExecutableSchema(supersimple.Config{Resolvers: &supersimple.Resolver{}}),
+	// STORE=memory runs the whole schema without a database.
+	var db *supersimple.Mongo
+	var users supersimple.UserStore
+	var library supersimple.LibraryStore
+	var audit supersimple.AuditStore
+	if cfg.Store == "memory" {
+		users = supersimple.NewMemoryUserStore()
+		library = supersimple.NewMemoryLibraryStore()
+		audit = supersimple.NewMemoryAuditStore()
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		db, err = supersimple.NewMongo(ctx, cfg.mongoOptions())
+		cancel()
+		if err != nil {
+			log.Fatalf("cannot connect to MongoDB: %v", err)
+		}
+
+		// Pending migrations are applied on every start. Other instances
+		// starting at the same time wait for the lock instead.
+		migrator, err := supersimple.NewMigrator(db, supersimple.Migrations)
+		if err != nil {
+			log.Fatalf("invalid migrations: %v", err)
+		}
+		ctx, cancel = context.WithTimeout(context.Background(), supersimple.DefaultMigrationLease)
+		applied, err := migrator.Up(ctx, 0)
+		cancel()
+		if err != nil {
+			log.Fatalf("cannot migrate: %v", err)
+		}
+		if len(applied) > 0 {
+			log.Printf("applied migrations %v", applied)
+		}
+
+		// Indexes are ensured on every start. Existing duplicate names
+		// must be resolved before the unique name index can be built.
+		ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+		err = db.EnsureIndexes(ctx, cfg.indexes())
+		cancel()
+		if err != nil {
+			log.Fatalf("cannot bootstrap indexes: %v", err)
+		}
+		users = supersimple.NewMongoUserStore(db)
+		library = supersimple.NewMongoLibraryStore(db)
+		audit = supersimple.NewMongoAuditStore(db)
+	}
+
+	// A change stream feeds subscriptions when Mongo runs as a replica set.
+	// Otherwise the resolvers publish each write in-process.
+	events := supersimple.NewUserEvents()
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	if store, ok := users.(*supersimple.MongoUserStore); ok {
+		if err := store.Watch(watchCtx, events); err != nil {
+			log.Printf("users change stream unavailable, publishing in-process: %v", err)
+		}
+	}
+
+	// Soft-deleted users are kept for deletedRetention, 720h by default.
+	purgeCtx, stopPurging := context.WithCancel(context.Background())
+	defer stopPurging()
+	go supersimple.PurgeDeleted(purgeCtx, users, time.Duration(cfg.DeletedRetention), supersimple.DefaultPurgeInterval)
+
+	// Persisted queries are cached in memory in front of Redis. Without
+	// redis.addrs, or while Redis is unreachable, only memory is used.
+	var remote remoteCache
+	if len(cfg.Redis.Addrs) > 0 {
+		redisOpts, err := cfg.redisOptions()
+		if err != nil {
+			log.Fatal(err)
+		}
+		remote = NewCache(redisOpts, time.Duration(cfg.Redis.APQTTL))
+	}
+	cache := NewTieredCache(NewMemoryCache(cfg.APQCache.Size, time.Duration(cfg.APQCache.TTL)), remote, cfg.APQCache.MaxQuerySize)
+
+	// The default mux is not used: importing expvar registers /debug/vars
+	// on it, which would publish the command line to every client.
+	mux := http.NewServeMux()
+	mux.Handle("/", handler.Playground("GraphQL playground", "/query"))
+	// Requests bearing the token of an actor, or adminToken, act as that
+	// actor. Admins may use the admin-only mutations.
+	mux.Handle("/query", supersimple.RecoverMiddleware(supersimple.AuthMiddleware(cfg.identities(), supersimple.LoaderMiddleware(users, library, time.Duration(cfg.LoaderWait), handler.GraphQL(
+		supersimple.NewExecutableSchema(supersimple.Config{
+			Resolvers:  &supersimple.Resolver{UserStore: users, LibraryStore: library, Events: events, Audit: audit},
+			Directives: supersimple.Directives(),
+		}),
 		handler.EnablePersistedQueryCache(cache),
-	))
-	log.Printf("connect to http://localhost:%s/ for GraphQL playground", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+		handler.ErrorPresenter(supersimple.ErrorPresenter),
+		handler.RecoverFunc(supersimple.Recover),
+	)))))
+
+	srv := &http.Server{Addr: ":" + cfg.Port, Handler: mux}
+	go func() {
+		log.Printf("connect to http://localhost:%s/ for GraphQL playground", cfg.Port)
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// Loader batch sizes and APQ cache counters are published at
+	// /debug/vars on a separate listener, on localhost unless configured
+	// otherwise.
+	var metricsSrv *http.Server
+	if cfg.MetricsAddr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/debug/vars", metricsHandler())
+		metricsSrv = &http.Server{Addr: cfg.MetricsAddr, Handler: metricsMux}
+		go func() {
+			log.Printf("metrics at http://%s/debug/vars", cfg.MetricsAddr)
+			if err := metricsSrv.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("http shutdown: %v", err)
+	}
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(ctx); err != nil {
+			log.Printf("metrics shutdown: %v", err)
+		}
+	}
+	if db != nil {
+		if err := db.Disconnect(ctx); err != nil {
+			log.Printf("mongo disconnect: %v", err)
+		}
+	}
 }
 
 // package main
@@ -0,0 +1,155 @@
+package supersimple
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	supersimple "github.com/allen-woods/supersimple/models"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrNotFound is returned by a store when no document matches.
+var ErrNotFound = errors.New("not found")
+
+// ErrAmbiguous is returned by a store when a lookup that expects a single
+// document matches more than one.
+var ErrAmbiguous = errors.New("more than one document matches")
+
+// UserStore persists users. Implementations must be safe for concurrent use
+// and must behave identically, so that resolvers can run against either one.
+//
+// Every write increments User.Version and sets User.UpdatedAt; inserts
+// also set User.CreatedAt. Writes that take an expected version fail with
+// ErrVersionConflict if it is non-nil and differs from the stored one.
+//
+// Names are unique among live users, ignoring case; a write that would
+// break this fails with ErrDuplicate.
+//
+// Deletes are soft. Soft-deleted users are invisible to every method, as
+// if they did not exist, except to filters with IncludeDeleted, Restore
+// and Purge.
+type UserStore interface {
+	// Insert assigns a new ObjectID to u and stores it.
+	Insert(ctx context.Context, u *supersimple.User) error
+	// FindOne returns the only user matching filter. It returns
+	// ErrNotFound if there is none and ErrAmbiguous if there are several.
+	FindOne(ctx context.Context, filter supersimple.UserFilter) (*supersimple.User, error)
+	// Find returns every user matching filter, sorted by orderBy and
+	// then by ID.
+	Find(ctx context.Context, filter supersimple.UserFilter, orderBy []*UserOrderBy) ([]*supersimple.User, error)
+	// Page returns up to limit users matching filter whose IDs lie strictly
+	// between after and before, either of which may be nil, in ID order. By default the
+	// users nearest after are returned; fromEnd returns those nearest
+	// before instead. more reports whether users were left out on the
+	// side the page was taken from.
+	Page(ctx context.Context, filter supersimple.UserFilter, after, before *primitive.ObjectID, limit int, fromEnd bool) (users []*supersimple.User, more bool, err error)
+	// UpdateName sets the name of a user and returns the updated document.
+	UpdateName(ctx context.Context, id primitive.ObjectID, name string, expected *int) (*supersimple.User, error)
+	// Replace overwrites the whole document with u's ID and sets u.Version.
+	// If no such user exists it returns ErrNotFound, unless upsert is set,
+	// in which case u is inserted and inserted is true.
+	Replace(ctx context.Context, u *supersimple.User, upsert bool, expected *int) (inserted bool, err error)
+	// Delete soft-deletes a user by setting DeletedAt and returns the
+	// updated document.
+	Delete(ctx context.Context, id primitive.ObjectID, expected *int) (*supersimple.User, error)
+
+	// InsertMany assigns each user a new ObjectID and stores it. Every user
+	// is attempted even if some fail; errs holds the failure, or nil, for
+	// the user at the same index.
+	InsertMany(ctx context.Context, users []*supersimple.User) (errs []error, err error)
+	// UpdateMany applies patch to every user matching filter and returns
+	// the updated documents in ID order.
+	UpdateMany(ctx context.Context, filter supersimple.UserFilter, patch supersimple.UserPatch) ([]*supersimple.User, error)
+	// DeleteMany soft-deletes the users with the given ids and returns the
+	// updated documents, in ID order.
+	DeleteMany(ctx context.Context, ids []primitive.ObjectID) ([]*supersimple.User, error)
+
+	// Count returns the number of users matching filter.
+	Count(ctx context.Context, filter supersimple.UserFilter) (int64, error)
+	// UpdateAll applies patch to every user and returns the updated
+	// documents in ID order.
+	UpdateAll(ctx context.Context, patch supersimple.UserPatch) ([]*supersimple.User, error)
+	// DeleteAll soft-deletes every user and returns the updated documents
+	// in ID order.
+	DeleteAll(ctx context.Context) ([]*supersimple.User, error)
+
+	// Restore clears DeletedAt on a soft-deleted user and returns the
+	// updated document. It returns ErrNotFound unless the user is deleted.
+	Restore(ctx context.Context, id primitive.ObjectID) (*supersimple.User, error)
+	// Purge permanently removes the users soft-deleted before t and
+	// returns how many there were.
+	Purge(ctx context.Context, t time.Time) (int64, error)
+}
+
+// ErrDuplicate is returned by a store when a write would break a unique
+// index.
+var ErrDuplicate = errors.New("duplicate key")
+
+// ErrVersionConflict is returned by a store when a write expected a
+// different version of the document than the one stored.
+var ErrVersionConflict = errors.New("document version does not match")
+
+// ErrUnknownReference is returned by a store when a document refers to
+// another document that does not exist.
+var ErrUnknownReference = errors.New("reference to a document that does not exist")
+
+// LibraryStore persists authors and books. A book refers to its authors by
+// ID; the relationship is resolved in both directions by the store so that
+// Mongo can join the two collections in a single aggregation.
+type LibraryStore interface {
+	InsertAuthor(ctx context.Context, a *supersimple.Author) error
+	FindAuthor(ctx context.Context, id primitive.ObjectID) (*supersimple.Author, error)
+	// FindAuthors returns every author in ID order.
+	FindAuthors(ctx context.Context) ([]*supersimple.Author, error)
+	UpdateAuthorName(ctx context.Context, id primitive.ObjectID, name string) (*supersimple.Author, error)
+	// DeleteAuthor removes an author and drops it from every book that
+	// referred to it.
+	DeleteAuthor(ctx context.Context, id primitive.ObjectID) (*supersimple.Author, error)
+
+	// InsertBook stores b. It returns ErrUnknownReference if any of its
+	// authors does not exist.
+	InsertBook(ctx context.Context, b *supersimple.Book) error
+	FindBook(ctx context.Context, id primitive.ObjectID) (*supersimple.Book, error)
+	// FindBooks returns every book in ID order.
+	FindBooks(ctx context.Context) ([]*supersimple.Book, error)
+	// UpdateBook applies patch to a book. It returns ErrUnknownReference if
+	// the patch names an author that does not exist.
+	UpdateBook(ctx context.Context, id primitive.ObjectID, patch supersimple.BookPatch) (*supersimple.Book, error)
+	DeleteBook(ctx context.Context, id primitive.ObjectID) (*supersimple.Book, error)
+
+	// BooksByAuthor returns, for each of the given authors, the books that
+	// list it as an author, in ID order.
+	BooksByAuthor(ctx context.Context, authorIDs []primitive.ObjectID) (map[primitive.ObjectID][]*supersimple.Book, error)
+	// AuthorsByBook returns, for each of the given books, its authors in
+	// ID order.
+	AuthorsByBook(ctx context.Context, bookIDs []primitive.ObjectID) (map[primitive.ObjectID][]*supersimple.Author, error)
+}
+
+// AuditStore persists the audit log. Entries are never changed once
+// written.
+type AuditStore interface {
+	// Record assigns a new ObjectID to e and stores it.
+	Record(ctx context.Context, e *supersimple.AuditEntry) error
+	// Page returns up to limit entries about target, or about anything if
+	// target is nil, oldest first and starting after the entry with ID
+	// after, if given. more reports whether further entries exist.
+	Page(ctx context.Context, target, after *primitive.ObjectID, limit int) (entries []*supersimple.AuditEntry, more bool, err error)
+}
+
+// now is the time a write is stamped with, truncated to the millisecond
+// precision that Mongo stores.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// onlyUser applies the FindOne contract to a list of matches.
+func onlyUser(users []*supersimple.User) (*supersimple.User, error) {
+	switch len(users) {
+	case 0:
+		return nil, ErrNotFound
+	case 1:
+		return users[0], nil
+	}
+	return nil, ErrAmbiguous
+}
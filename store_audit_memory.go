@@ -0,0 +1,52 @@
+package supersimple
+
+import (
+	"context"
+	"sync"
+
+	supersimple "github.com/allen-woods/supersimple/models"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryAuditStore is an AuditStore held entirely in process memory.
+type MemoryAuditStore struct {
+	mu      sync.RWMutex
+	entries []supersimple.AuditEntry
+}
+
+// NewMemoryAuditStore returns an empty in-memory audit log.
+func NewMemoryAuditStore() *MemoryAuditStore {
+	return &MemoryAuditStore{}
+}
+
+func (s *MemoryAuditStore) Record(ctx context.Context, e *supersimple.AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e.ID = primitive.NewObjectID()
+	s.entries = append(s.entries, *e)
+	return nil
+}
+
+// Page relies on entries being appended in ID order, which holds because
+// Record assigns IDs under the lock.
+func (s *MemoryAuditStore) Page(ctx context.Context, target, after *primitive.ObjectID, limit int) ([]*supersimple.AuditEntry, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var results []*supersimple.AuditEntry
+	for _, e := range s.entries {
+		if target != nil && (e.TargetID == nil || *e.TargetID != *target) {
+			continue
+		}
+		if after != nil && compareIDs(e.ID, *after) <= 0 {
+			continue
+		}
+		if len(results) == limit {
+			return results, true, nil
+		}
+		e := e
+		results = append(results, &e)
+	}
+	return results, false, nil
+}
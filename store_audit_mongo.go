@@ -0,0 +1,76 @@
+package supersimple
+
+import (
+	"context"
+
+	supersimple "github.com/allen-woods/supersimple/models"
+	"go.mongodb.org/mongo-driver/bson"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoAuditStore is an AuditStore backed by the "audit" collection.
+type MongoAuditStore struct {
+	db         *Mongo
+	collection *mongo.Collection
+}
+
+// NewMongoAuditStore returns a store over the "audit" collection.
+func NewMongoAuditStore(m *Mongo) *MongoAuditStore {
+	return &MongoAuditStore{db: m, collection: m.Collection("audit")}
+}
+
+func (s *MongoAuditStore) Record(ctx context.Context, e *supersimple.AuditEntry) error {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	e.ID = primitive.NewObjectID()
+	if _, err := s.collection.InsertOne(ctx, *e); err != nil {
+		e.ID = primitive.NilObjectID
+		return err
+	}
+	return nil
+}
+
+func (s *MongoAuditStore) Page(ctx context.Context, target, after *primitive.ObjectID, limit int) ([]*supersimple.AuditEntry, bool, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	filter := bson.D{}
+	if target != nil {
+		filter = append(filter, bson.E{Key: "targetId", Value: *target})
+	}
+	if after != nil {
+		filter = append(filter, bson.E{Key: "_id", Value: bson.D{{Key: "$gt", Value: *after}}})
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(int64(limit) + 1)
+
+	cur, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, false, err
+	}
+	defer cur.Close(ctx)
+
+	var results []*supersimple.AuditEntry
+
+	for cur.Next(ctx) {
+		var elem supersimple.AuditEntry
+		if err := cur.Decode(&elem); err != nil {
+			return nil, false, err
+		}
+		results = append(results, &elem)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, false, err
+	}
+
+	more := len(results) > limit
+	if more {
+		results = results[:limit]
+	}
+	return results, more, nil
+}
@@ -0,0 +1,242 @@
+package supersimple
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	supersimple "github.com/allen-woods/supersimple/models"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryLibraryStore is a LibraryStore held entirely in process memory.
+// Relationships are resolved by scanning, mirroring the Mongo $lookup.
+type MemoryLibraryStore struct {
+	mu      sync.RWMutex
+	authors map[primitive.ObjectID]supersimple.Author
+	books   map[primitive.ObjectID]supersimple.Book
+}
+
+// NewMemoryLibraryStore returns an empty in-memory store.
+func NewMemoryLibraryStore() *MemoryLibraryStore {
+	return &MemoryLibraryStore{
+		authors: make(map[primitive.ObjectID]supersimple.Author),
+		books:   make(map[primitive.ObjectID]supersimple.Book),
+	}
+}
+
+func (s *MemoryLibraryStore) InsertAuthor(ctx context.Context, a *supersimple.Author) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a.ID = primitive.NewObjectID()
+	s.authors[a.ID] = *a
+	return nil
+}
+
+func (s *MemoryLibraryStore) FindAuthor(ctx context.Context, id primitive.ObjectID) (*supersimple.Author, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.authors[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &a, nil
+}
+
+func (s *MemoryLibraryStore) FindAuthors(ctx context.Context) ([]*supersimple.Author, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.sortedAuthors(func(*supersimple.Author) bool { return true }), nil
+}
+
+func (s *MemoryLibraryStore) UpdateAuthorName(ctx context.Context, id primitive.ObjectID, name string) (*supersimple.Author, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.authors[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	a.Name = name
+	s.authors[id] = a
+	return &a, nil
+}
+
+func (s *MemoryLibraryStore) DeleteAuthor(ctx context.Context, id primitive.ObjectID) (*supersimple.Author, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.authors[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(s.authors, id)
+
+	for bookID, b := range s.books {
+		if !containsID(b.AuthorIDs, id) {
+			continue
+		}
+		ids := make([]primitive.ObjectID, 0, len(b.AuthorIDs)-1)
+		for _, v := range b.AuthorIDs {
+			if v != id {
+				ids = append(ids, v)
+			}
+		}
+		b.AuthorIDs = ids
+		s.books[bookID] = b
+	}
+	return &a, nil
+}
+
+func (s *MemoryLibraryStore) InsertBook(ctx context.Context, b *supersimple.Book) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkAuthors(b.AuthorIDs); err != nil {
+		return err
+	}
+
+	b.ID = primitive.NewObjectID()
+	s.books[b.ID] = copyBook(*b)
+	return nil
+}
+
+func (s *MemoryLibraryStore) FindBook(ctx context.Context, id primitive.ObjectID) (*supersimple.Book, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	b, ok := s.books[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	b = copyBook(b)
+	return &b, nil
+}
+
+func (s *MemoryLibraryStore) FindBooks(ctx context.Context) ([]*supersimple.Book, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.sortedBooks(func(*supersimple.Book) bool { return true }), nil
+}
+
+func (s *MemoryLibraryStore) UpdateBook(ctx context.Context, id primitive.ObjectID, patch supersimple.BookPatch) (*supersimple.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.books[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if patch.Title != nil {
+		b.Title = *patch.Title
+	}
+	if patch.AuthorIds != nil {
+		if err := s.checkAuthors(patch.AuthorIds); err != nil {
+			return nil, err
+		}
+		b.AuthorIDs = patch.AuthorIds
+	}
+	b = copyBook(b)
+	s.books[id] = b
+	return &b, nil
+}
+
+func (s *MemoryLibraryStore) DeleteBook(ctx context.Context, id primitive.ObjectID) (*supersimple.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.books[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(s.books, id)
+	return &b, nil
+}
+
+func (s *MemoryLibraryStore) BooksByAuthor(ctx context.Context, authorIDs []primitive.ObjectID) (map[primitive.ObjectID][]*supersimple.Book, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	results := make(map[primitive.ObjectID][]*supersimple.Book, len(authorIDs))
+	for _, id := range authorIDs {
+		if _, ok := s.authors[id]; !ok {
+			continue
+		}
+		id := id
+		results[id] = s.sortedBooks(func(b *supersimple.Book) bool {
+			return containsID(b.AuthorIDs, id)
+		})
+	}
+	return results, nil
+}
+
+func (s *MemoryLibraryStore) AuthorsByBook(ctx context.Context, bookIDs []primitive.ObjectID) (map[primitive.ObjectID][]*supersimple.Author, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	results := make(map[primitive.ObjectID][]*supersimple.Author, len(bookIDs))
+	for _, id := range bookIDs {
+		b, ok := s.books[id]
+		if !ok {
+			continue
+		}
+		results[id] = s.sortedAuthors(func(a *supersimple.Author) bool {
+			return containsID(b.AuthorIDs, a.ID)
+		})
+	}
+	return results, nil
+}
+
+// checkAuthors returns ErrUnknownReference unless every id names an
+// author. Callers must hold s.mu.
+func (s *MemoryLibraryStore) checkAuthors(ids []primitive.ObjectID) error {
+	for _, id := range ids {
+		if _, ok := s.authors[id]; !ok {
+			return ErrUnknownReference
+		}
+	}
+	return nil
+}
+
+// sortedAuthors returns copies of the matching authors in ID order.
+// Callers must hold s.mu.
+func (s *MemoryLibraryStore) sortedAuthors(match func(*supersimple.Author) bool) []*supersimple.Author {
+	results := []*supersimple.Author{}
+	for _, a := range s.authors {
+		a := a
+		if match(&a) {
+			results = append(results, &a)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return compareIDs(results[i].ID, results[j].ID) < 0
+	})
+	return results
+}
+
+// sortedBooks returns copies of the matching books in ID order. Callers
+// must hold s.mu.
+func (s *MemoryLibraryStore) sortedBooks(match func(*supersimple.Book) bool) []*supersimple.Book {
+	results := []*supersimple.Book{}
+	for _, b := range s.books {
+		b := copyBook(b)
+		if match(&b) {
+			results = append(results, &b)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return compareIDs(results[i].ID, results[j].ID) < 0
+	})
+	return results
+}
+
+// copyBook returns b with its own AuthorIDs slice, so that callers cannot
+// modify the stored document.
+func copyBook(b supersimple.Book) supersimple.Book {
+	b.AuthorIDs = append([]primitive.ObjectID{}, b.AuthorIDs...)
+	return b
+}
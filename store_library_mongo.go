@@ -0,0 +1,303 @@
+package supersimple
+
+import (
+	"context"
+	"sort"
+
+	supersimple "github.com/allen-woods/supersimple/models"
+	"go.mongodb.org/mongo-driver/bson"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoLibraryStore is a LibraryStore backed by the "authors" and "books"
+// collections. Relationships are resolved with $lookup.
+type MongoLibraryStore struct {
+	db      *Mongo
+	authors *mongo.Collection
+	books   *mongo.Collection
+}
+
+// NewMongoLibraryStore returns a store over the "authors" and "books"
+// collections.
+func NewMongoLibraryStore(m *Mongo) *MongoLibraryStore {
+	return &MongoLibraryStore{db: m, authors: m.Collection("authors"), books: m.Collection("books")}
+}
+
+func (s *MongoLibraryStore) InsertAuthor(ctx context.Context, a *supersimple.Author) error {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	a.ID = primitive.NewObjectID()
+	if _, err := s.authors.InsertOne(ctx, *a); err != nil {
+		a.ID = primitive.NilObjectID
+		return err
+	}
+	return nil
+}
+
+func (s *MongoLibraryStore) FindAuthor(ctx context.Context, id primitive.ObjectID) (*supersimple.Author, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	var a supersimple.Author
+
+	err := s.authors.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&a)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	return &a, nil
+}
+
+func (s *MongoLibraryStore) FindAuthors(ctx context.Context) ([]*supersimple.Author, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	var results []*supersimple.Author
+	err := findAll(ctx, s.authors, bson.D{}, func(cur *mongo.Cursor) error {
+		var elem supersimple.Author
+		if err := cur.Decode(&elem); err != nil {
+			return err
+		}
+		results = append(results, &elem)
+		return nil
+	})
+	return results, err
+}
+
+func (s *MongoLibraryStore) UpdateAuthorName(ctx context.Context, id primitive.ObjectID, name string) (*supersimple.Author, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "name", Value: name}}},
+	}
+
+	opts := options.FindOneAndUpdate()
+	opts.SetReturnDocument(options.After)
+
+	var a supersimple.Author
+
+	err := s.authors.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: id}}, update, opts).Decode(&a)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	return &a, nil
+}
+
+func (s *MongoLibraryStore) DeleteAuthor(ctx context.Context, id primitive.ObjectID) (*supersimple.Author, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	var a supersimple.Author
+
+	err := s.authors.FindOneAndDelete(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&a)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+
+	pull := bson.D{
+		{Key: "$pull", Value: bson.D{{Key: "authorIds", Value: id}}},
+	}
+	if _, err := s.books.UpdateMany(ctx, bson.D{{Key: "authorIds", Value: id}}, pull); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (s *MongoLibraryStore) InsertBook(ctx context.Context, b *supersimple.Book) error {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	if err := s.checkAuthors(ctx, b.AuthorIDs); err != nil {
+		return err
+	}
+
+	b.ID = primitive.NewObjectID()
+	if _, err := s.books.InsertOne(ctx, *b); err != nil {
+		b.ID = primitive.NilObjectID
+		return err
+	}
+	return nil
+}
+
+func (s *MongoLibraryStore) FindBook(ctx context.Context, id primitive.ObjectID) (*supersimple.Book, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	var b supersimple.Book
+
+	err := s.books.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&b)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	return &b, nil
+}
+
+func (s *MongoLibraryStore) FindBooks(ctx context.Context) ([]*supersimple.Book, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	var results []*supersimple.Book
+	err := findAll(ctx, s.books, bson.D{}, func(cur *mongo.Cursor) error {
+		var elem supersimple.Book
+		if err := cur.Decode(&elem); err != nil {
+			return err
+		}
+		results = append(results, &elem)
+		return nil
+	})
+	return results, err
+}
+
+func (s *MongoLibraryStore) UpdateBook(ctx context.Context, id primitive.ObjectID, patch supersimple.BookPatch) (*supersimple.Book, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	set := bson.D{}
+	if patch.Title != nil {
+		set = append(set, bson.E{Key: "title", Value: *patch.Title})
+	}
+	if patch.AuthorIds != nil {
+		if err := s.checkAuthors(ctx, patch.AuthorIds); err != nil {
+			return nil, err
+		}
+		set = append(set, bson.E{Key: "authorIds", Value: patch.AuthorIds})
+	}
+
+	opts := options.FindOneAndUpdate()
+	opts.SetReturnDocument(options.After)
+
+	var b supersimple.Book
+
+	err := s.books.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: id}}, bson.D{{Key: "$set", Value: set}}, opts).Decode(&b)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	return &b, nil
+}
+
+func (s *MongoLibraryStore) DeleteBook(ctx context.Context, id primitive.ObjectID) (*supersimple.Book, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	var b supersimple.Book
+
+	err := s.books.FindOneAndDelete(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&b)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	return &b, nil
+}
+
+func (s *MongoLibraryStore) BooksByAuthor(ctx context.Context, authorIDs []primitive.ObjectID) (map[primitive.ObjectID][]*supersimple.Book, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	// An author matches every book whose authorIds array contains it.
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: authorIDs}}}}}},
+		{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: s.books.Name()},
+			{Key: "localField", Value: "_id"},
+			{Key: "foreignField", Value: "authorIds"},
+			{Key: "as", Value: "books"},
+		}}},
+		{{Key: "$project", Value: bson.D{{Key: "books", Value: 1}}}},
+	}
+
+	cur, err := s.authors.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	results := make(map[primitive.ObjectID][]*supersimple.Book, len(authorIDs))
+	for cur.Next(ctx) {
+		var elem struct {
+			ID    primitive.ObjectID  `bson:"_id"`
+			Books []*supersimple.Book `bson:"books"`
+		}
+		if err := cur.Decode(&elem); err != nil {
+			return nil, err
+		}
+		sort.Slice(elem.Books, func(i, j int) bool {
+			return compareIDs(elem.Books[i].ID, elem.Books[j].ID) < 0
+		})
+		results[elem.ID] = elem.Books
+	}
+	return results, cur.Err()
+}
+
+func (s *MongoLibraryStore) AuthorsByBook(ctx context.Context, bookIDs []primitive.ObjectID) (map[primitive.ObjectID][]*supersimple.Author, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bookIDs}}}}}},
+		{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: s.authors.Name()},
+			{Key: "localField", Value: "authorIds"},
+			{Key: "foreignField", Value: "_id"},
+			{Key: "as", Value: "authors"},
+		}}},
+		{{Key: "$project", Value: bson.D{{Key: "authors", Value: 1}}}},
+	}
+
+	cur, err := s.books.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	results := make(map[primitive.ObjectID][]*supersimple.Author, len(bookIDs))
+	for cur.Next(ctx) {
+		var elem struct {
+			ID      primitive.ObjectID    `bson:"_id"`
+			Authors []*supersimple.Author `bson:"authors"`
+		}
+		if err := cur.Decode(&elem); err != nil {
+			return nil, err
+		}
+		sort.Slice(elem.Authors, func(i, j int) bool {
+			return compareIDs(elem.Authors[i].ID, elem.Authors[j].ID) < 0
+		})
+		results[elem.ID] = elem.Authors
+	}
+	return results, cur.Err()
+}
+
+// checkAuthors returns ErrUnknownReference unless every id names an author.
+func (s *MongoLibraryStore) checkAuthors(ctx context.Context, ids []primitive.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	n, err := s.authors.CountDocuments(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}})
+	if err != nil {
+		return err
+	}
+	if int(n) != len(uniqueIDs(ids)) {
+		return ErrUnknownReference
+	}
+	return nil
+}
+
+// findAll calls decode for every document matching filter, in ID order.
+func findAll(ctx context.Context, collection *mongo.Collection, filter interface{}, decode func(*mongo.Cursor) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		if err := decode(cur); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
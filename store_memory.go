@@ -0,0 +1,331 @@
+package supersimple
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	supersimple "github.com/allen-woods/supersimple/models"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryUserStore is a UserStore held entirely in process memory. It is
+// intended for tests and local demos that should not need a database.
+type MemoryUserStore struct {
+	mu    sync.RWMutex
+	users map[primitive.ObjectID]supersimple.User
+}
+
+// NewMemoryUserStore returns an empty in-memory store.
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{users: make(map[primitive.ObjectID]supersimple.User)}
+}
+
+func (s *MemoryUserStore) Insert(ctx context.Context, u *supersimple.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.nameTaken(u.Name, primitive.NilObjectID) {
+		return ErrDuplicate
+	}
+	u.ID = primitive.NewObjectID()
+	u.Version = 1
+	u.CreatedAt = now()
+	u.UpdatedAt = u.CreatedAt
+	s.users[u.ID] = *u
+	return nil
+}
+
+func (s *MemoryUserStore) FindOne(ctx context.Context, filter supersimple.UserFilter) (*supersimple.User, error) {
+	users, err := s.Find(ctx, filter, nil)
+	if err != nil {
+		return nil, err
+	}
+	return onlyUser(users)
+}
+
+func (s *MemoryUserStore) Find(ctx context.Context, filter supersimple.UserFilter, orderBy []*UserOrderBy) ([]*supersimple.User, error) {
+	match, err := userMatcher(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var results []*supersimple.User
+	for _, u := range s.sorted() {
+		if match(u) {
+			results = append(results, u)
+		}
+	}
+	less := userLess(orderBy)
+	sort.SliceStable(results, func(i, j int) bool {
+		return less(results[i], results[j])
+	})
+	return results, nil
+}
+
+func (s *MemoryUserStore) Page(ctx context.Context, filter supersimple.UserFilter, after, before *primitive.ObjectID, limit int, fromEnd bool) ([]*supersimple.User, bool, error) {
+	match, err := userMatcher(filter)
+	if err != nil {
+		return nil, false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var window []*supersimple.User
+	for _, u := range s.sorted() {
+		if !match(u) {
+			continue
+		}
+		if after != nil && compareIDs(u.ID, *after) <= 0 {
+			continue
+		}
+		if before != nil && compareIDs(u.ID, *before) >= 0 {
+			continue
+		}
+		window = append(window, u)
+	}
+
+	if len(window) <= limit {
+		return window, false, nil
+	}
+	if fromEnd {
+		return window[len(window)-limit:], true, nil
+	}
+	return window[:limit], true, nil
+}
+
+func (s *MemoryUserStore) UpdateName(ctx context.Context, id primitive.ObjectID, name string, expected *int) (*supersimple.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.current(id, expected)
+	if err != nil {
+		return nil, err
+	}
+	if s.nameTaken(name, id) {
+		return nil, ErrDuplicate
+	}
+	u.Name = name
+	u.Version++
+	u.UpdatedAt = now()
+	s.users[id] = u
+	return &u, nil
+}
+
+func (s *MemoryUserStore) Replace(ctx context.Context, u *supersimple.User, upsert bool, expected *int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A soft-deleted user counts as missing, so upserting over one
+	// replaces it with a new, live user.
+	cur, exists := s.users[u.ID]
+	exists = exists && cur.DeletedAt == nil
+	switch {
+	case !exists && !upsert:
+		return false, ErrNotFound
+	case !exists && expected != nil:
+		return false, ErrVersionConflict
+	case exists && expected != nil && cur.Version != *expected:
+		return false, ErrVersionConflict
+	case s.nameTaken(u.Name, u.ID):
+		return false, ErrDuplicate
+	}
+	u.Version = cur.Version + 1
+	u.UpdatedAt = now()
+	u.CreatedAt = cur.CreatedAt
+	if !exists {
+		u.CreatedAt = u.UpdatedAt
+	}
+	s.users[u.ID] = *u
+	return !exists, nil
+}
+
+func (s *MemoryUserStore) Delete(ctx context.Context, id primitive.ObjectID, expected *int) (*supersimple.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.current(id, expected)
+	if err != nil {
+		return nil, err
+	}
+	softDeleteUser(&u, now())
+	s.users[id] = u
+	return &u, nil
+}
+
+func (s *MemoryUserStore) InsertMany(ctx context.Context, users []*supersimple.User) ([]error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := now()
+	errs := make([]error, len(users))
+	for i, u := range users {
+		if s.nameTaken(u.Name, primitive.NilObjectID) {
+			errs[i] = ErrDuplicate
+			continue
+		}
+		u.ID = primitive.NewObjectID()
+		u.Version = 1
+		u.CreatedAt = t
+		u.UpdatedAt = t
+		s.users[u.ID] = *u
+	}
+	return errs, nil
+}
+
+func (s *MemoryUserStore) UpdateMany(ctx context.Context, filter supersimple.UserFilter, patch supersimple.UserPatch) ([]*supersimple.User, error) {
+	match, err := userMatcher(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var results []*supersimple.User
+	for _, u := range s.sorted() {
+		if match(u) {
+			results = append(results, u)
+		}
+	}
+	if patch.Name != nil && (len(results) > 1 || len(results) == 1 && s.nameTaken(*patch.Name, results[0].ID)) {
+		return nil, ErrDuplicate
+	}
+
+	t := now()
+	for _, u := range results {
+		if patch.Name != nil {
+			u.Name = *patch.Name
+		}
+		u.Version++
+		u.UpdatedAt = t
+		s.users[u.ID] = *u
+	}
+	return results, nil
+}
+
+func (s *MemoryUserStore) DeleteMany(ctx context.Context, ids []primitive.ObjectID) ([]*supersimple.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := now()
+	var results []*supersimple.User
+	for _, u := range s.sorted() {
+		if !containsID(ids, u.ID) || u.DeletedAt != nil {
+			continue
+		}
+		softDeleteUser(u, t)
+		s.users[u.ID] = *u
+		results = append(results, u)
+	}
+	return results, nil
+}
+
+func (s *MemoryUserStore) Count(ctx context.Context, filter supersimple.UserFilter) (int64, error) {
+	users, err := s.Find(ctx, filter, nil)
+	return int64(len(users)), err
+}
+
+func (s *MemoryUserStore) UpdateAll(ctx context.Context, patch supersimple.UserPatch) ([]*supersimple.User, error) {
+	return s.UpdateMany(ctx, supersimple.UserFilter{}, patch)
+}
+
+func (s *MemoryUserStore) DeleteAll(ctx context.Context) ([]*supersimple.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := now()
+	var results []*supersimple.User
+	for _, u := range s.sorted() {
+		if u.DeletedAt == nil {
+			softDeleteUser(u, t)
+			s.users[u.ID] = *u
+			results = append(results, u)
+		}
+	}
+	return results, nil
+}
+
+func (s *MemoryUserStore) Restore(ctx context.Context, id primitive.ObjectID) (*supersimple.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok || u.DeletedAt == nil {
+		return nil, ErrNotFound
+	}
+	if s.nameTaken(u.Name, id) {
+		return nil, ErrDuplicate
+	}
+	u.DeletedAt = nil
+	u.Version++
+	u.UpdatedAt = now()
+	s.users[id] = u
+	return &u, nil
+}
+
+func (s *MemoryUserStore) Purge(ctx context.Context, t time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for id, u := range s.users {
+		if u.DeletedAt != nil && u.DeletedAt.Before(t) {
+			delete(s.users, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// current returns the stored user with the given id, checking its version
+// against expected when that is non-nil. Callers must hold s.mu.
+func (s *MemoryUserStore) current(id primitive.ObjectID, expected *int) (supersimple.User, error) {
+	u, ok := s.users[id]
+	if !ok || u.DeletedAt != nil {
+		return u, ErrNotFound
+	}
+	if expected != nil && u.Version != *expected {
+		return u, ErrVersionConflict
+	}
+	return u, nil
+}
+
+// nameTaken reports whether a live user other than except is called name,
+// ignoring case, mirroring the unique name index in DefaultIndexes.
+// Callers must hold s.mu.
+func (s *MemoryUserStore) nameTaken(name string, except primitive.ObjectID) bool {
+	for id, u := range s.users {
+		if id != except && u.DeletedAt == nil && strings.EqualFold(u.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// softDeleteUser marks u as deleted at t.
+func softDeleteUser(u *supersimple.User, t time.Time) {
+	u.DeletedAt = &t
+	u.UpdatedAt = t
+	u.Version++
+}
+
+// sorted returns copies of every user in ID order, matching the natural
+// order Mongo uses for ObjectIDs. Callers must hold s.mu.
+func (s *MemoryUserStore) sorted() []*supersimple.User {
+	results := make([]*supersimple.User, 0, len(s.users))
+	for _, u := range s.users {
+		u := u
+		results = append(results, &u)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return compareIDs(results[i].ID, results[j].ID) < 0
+	})
+	return results
+}
@@ -0,0 +1,272 @@
+package supersimple
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	supersimple "github.com/allen-woods/supersimple/models"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func intPtr(n int) *int { return &n }
+
+// newTestUserStore returns a memory store holding a user for each name,
+// in ID order.
+func newTestUserStore(t *testing.T, names ...string) (*MemoryUserStore, []*supersimple.User) {
+	t.Helper()
+	s := NewMemoryUserStore()
+	users := make([]*supersimple.User, len(names))
+	for i, name := range names {
+		users[i] = &supersimple.User{Name: name}
+		if err := s.Insert(context.Background(), users[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s, users
+}
+
+func TestMemoryUserStoreNames(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		write func(s *MemoryUserStore, users []*supersimple.User) error
+		want  error
+	}{
+		{"insert same case", func(s *MemoryUserStore, users []*supersimple.User) error {
+			return s.Insert(ctx, &supersimple.User{Name: "ann"})
+		}, ErrDuplicate},
+		{"insert other case", func(s *MemoryUserStore, users []*supersimple.User) error {
+			return s.Insert(ctx, &supersimple.User{Name: "ANN"})
+		}, ErrDuplicate},
+		{"insert many", func(s *MemoryUserStore, users []*supersimple.User) error {
+			errs, err := s.InsertMany(ctx, []*supersimple.User{{Name: "cat"}, {Name: "Ann"}})
+			if err != nil || errs[0] != nil {
+				t.Fatalf("InsertMany() = %v, %v", errs, err)
+			}
+			return errs[1]
+		}, ErrDuplicate},
+		{"rename", func(s *MemoryUserStore, users []*supersimple.User) error {
+			_, err := s.UpdateName(ctx, users[1].ID, "Ann", nil)
+			return err
+		}, ErrDuplicate},
+		{"rename own case", func(s *MemoryUserStore, users []*supersimple.User) error {
+			_, err := s.UpdateName(ctx, users[0].ID, "ANN", nil)
+			return err
+		}, nil},
+		{"replace", func(s *MemoryUserStore, users []*supersimple.User) error {
+			_, err := s.Replace(ctx, &supersimple.User{ID: users[1].ID, Name: "aNN"}, false, nil)
+			return err
+		}, ErrDuplicate},
+		{"update many", func(s *MemoryUserStore, users []*supersimple.User) error {
+			_, err := s.UpdateMany(ctx, supersimple.UserFilter{Ids: []primitive.ObjectID{users[1].ID}}, supersimple.UserPatch{Name: strPtr("Ann")})
+			return err
+		}, ErrDuplicate},
+		{"name of deleted user", func(s *MemoryUserStore, users []*supersimple.User) error {
+			if _, err := s.Delete(ctx, users[0].ID, nil); err != nil {
+				t.Fatal(err)
+			}
+			return s.Insert(ctx, &supersimple.User{Name: "Ann"})
+		}, nil},
+		{"restore over new user", func(s *MemoryUserStore, users []*supersimple.User) error {
+			if _, err := s.Delete(ctx, users[0].ID, nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Insert(ctx, &supersimple.User{Name: "ANN"}); err != nil {
+				t.Fatal(err)
+			}
+			_, err := s.Restore(ctx, users[0].ID)
+			return err
+		}, ErrDuplicate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, users := newTestUserStore(t, "ann", "bob")
+			if err := tt.write(s, users); err != tt.want {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryUserStoreVersions(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		write func(s *MemoryUserStore, u *supersimple.User, expected *int) (*supersimple.User, error)
+	}{
+		{"update name", func(s *MemoryUserStore, u *supersimple.User, expected *int) (*supersimple.User, error) {
+			return s.UpdateName(ctx, u.ID, "bob", expected)
+		}},
+		{"replace", func(s *MemoryUserStore, u *supersimple.User, expected *int) (*supersimple.User, error) {
+			r := &supersimple.User{ID: u.ID, Name: "bob"}
+			_, err := s.Replace(ctx, r, false, expected)
+			return r, err
+		}},
+		{"delete", func(s *MemoryUserStore, u *supersimple.User, expected *int) (*supersimple.User, error) {
+			return s.Delete(ctx, u.ID, expected)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, users := newTestUserStore(t, "ann")
+
+			if _, err := tt.write(s, users[0], intPtr(2)); err != ErrVersionConflict {
+				t.Fatalf("stale write: got %v, want %v", err, ErrVersionConflict)
+			}
+			u, err := tt.write(s, users[0], intPtr(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Version != 2 || u.UpdatedAt.Before(users[0].CreatedAt) {
+				t.Errorf("write gave version %d, updatedAt %v", u.Version, u.UpdatedAt)
+			}
+			if !u.CreatedAt.Equal(users[0].CreatedAt) {
+				t.Errorf("write changed createdAt to %v", u.CreatedAt)
+			}
+			if _, err := tt.write(s, users[0], intPtr(1)); err == nil {
+				t.Error("second write at version 1 succeeded")
+			}
+		})
+	}
+}
+
+func TestMemoryUserStoreReplaceDeleted(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name         string
+		upsert       bool
+		expected     *int
+		want         error
+		wantInserted bool
+	}{
+		{"replace", false, nil, ErrNotFound, false},
+		{"upsert at a version", true, intPtr(2), ErrVersionConflict, false},
+		{"upsert", true, nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, users := newTestUserStore(t, "ann")
+			id := users[0].ID
+			if _, err := s.Delete(ctx, id, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			u := &supersimple.User{ID: id, Name: "bob"}
+			inserted, err := s.Replace(ctx, u, tt.upsert, tt.expected)
+			if err != tt.want || inserted != tt.wantInserted {
+				t.Fatalf("Replace() = %v, %v, want %v, %v", inserted, err, tt.wantInserted, tt.want)
+			}
+			if err != nil {
+				return
+			}
+
+			got, err := s.FindOne(ctx, supersimple.UserFilter{Ids: []primitive.ObjectID{id}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Name != "bob" || got.DeletedAt != nil || got.CreatedAt.Before(users[0].CreatedAt) {
+				t.Errorf("upsert over a deleted user left %+v", got)
+			}
+		})
+	}
+}
+
+func TestMemoryUserStorePage(t *testing.T) {
+	ctx := context.Background()
+	s, users := newTestUserStore(t, "a", "b", "c", "d", "e")
+	if _, err := s.Delete(ctx, users[2].ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	id := func(i int) *primitive.ObjectID { return &users[i].ID }
+
+	tests := []struct {
+		name          string
+		after, before *primitive.ObjectID
+		limit         int
+		fromEnd       bool
+		want          string
+		wantMore      bool
+	}{
+		{"first", nil, nil, 2, false, "ab", true},
+		{"all", nil, nil, 4, false, "abde", false},
+		{"more than all", nil, nil, 10, false, "abde", false},
+		{"last", nil, nil, 2, true, "de", true},
+		{"last of all", nil, nil, 4, true, "abde", false},
+		{"after", id(0), nil, 2, false, "bd", true},
+		{"after deleted", id(2), nil, 2, false, "de", false},
+		{"before", nil, id(4), 2, true, "bd", true},
+		{"before from start", nil, id(4), 2, false, "ab", true},
+		{"between", id(0), id(4), 10, false, "bd", false},
+		{"between from end", id(0), id(4), 1, true, "d", true},
+		{"empty", id(3), id(4), 10, false, "", false},
+		{"zero", nil, nil, 0, false, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, more, err := s.Page(ctx, supersimple.UserFilter{}, tt.after, tt.before, tt.limit, tt.fromEnd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			for _, u := range page {
+				got += u.Name
+			}
+			if got != tt.want || more != tt.wantMore {
+				t.Errorf("Page() = %q, %v, want %q, %v", got, more, tt.want, tt.wantMore)
+			}
+		})
+	}
+
+	page, _, err := s.Page(ctx, supersimple.UserFilter{IncludeDeleted: true}, id(1), id(3), 10, false)
+	if err != nil || len(page) != 1 || page[0].Name != "c" {
+		t.Errorf("Page() with deleted users = %v, %v", page, err)
+	}
+}
+
+func TestMemoryUserStoreRestoreAndPurge(t *testing.T) {
+	ctx := context.Background()
+	s, users := newTestUserStore(t, "ann", "bob")
+
+	if _, err := s.Restore(ctx, users[0].ID); err != ErrNotFound {
+		t.Errorf("Restore() of a live user: got %v, want %v", err, ErrNotFound)
+	}
+	deleted, err := s.Delete(ctx, users[0].ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.FindOne(ctx, supersimple.UserFilter{Name: strPtr("ann")}); err != ErrNotFound {
+		t.Errorf("deleted user was found: %v", err)
+	}
+	if _, err := s.UpdateName(ctx, users[0].ID, "cat", nil); err != ErrNotFound {
+		t.Errorf("UpdateName() of a deleted user: got %v, want %v", err, ErrNotFound)
+	}
+
+	restored, err := s.Restore(ctx, users[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.DeletedAt != nil || restored.Version != deleted.Version+1 {
+		t.Errorf("Restore() = %+v", restored)
+	}
+
+	if _, err := s.Delete(ctx, users[1].ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.Purge(ctx, now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("Purge() of recent deletions = %d, %v", n, err)
+	}
+	if n, err := s.Purge(ctx, now().Add(time.Hour)); err != nil || n != 1 {
+		t.Errorf("Purge() = %d, %v, want 1", n, err)
+	}
+	if n, _ := s.Count(ctx, supersimple.UserFilter{IncludeDeleted: true}); n != 1 {
+		t.Errorf("%d users left after Purge(), want 1", n)
+	}
+}
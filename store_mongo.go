@@ -0,0 +1,613 @@
+package supersimple
+
+import (
+	"context"
+	"log"
+	"time"
+
+	supersimple "github.com/allen-woods/supersimple/models"
+	"go.mongodb.org/mongo-driver/bson"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoUserStore is a UserStore backed by a MongoDB collection.
+type MongoUserStore struct {
+	db         *Mongo
+	collection *mongo.Collection
+}
+
+// NewMongoUserStore returns a store over the "users" collection.
+func NewMongoUserStore(m *Mongo) *MongoUserStore {
+	return &MongoUserStore{db: m, collection: m.Collection("users")}
+}
+
+func (s *MongoUserStore) Insert(ctx context.Context, u *supersimple.User) error {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	u.ID = primitive.NewObjectID()
+	u.Version = 1
+	u.CreatedAt = now()
+	u.UpdatedAt = u.CreatedAt
+	if _, err := s.collection.InsertOne(ctx, *u); err != nil {
+		u.ID = primitive.NilObjectID
+		return mongoError(err)
+	}
+	return nil
+}
+
+func (s *MongoUserStore) FindOne(ctx context.Context, filter supersimple.UserFilter) (*supersimple.User, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	query, err := userFilterBSON(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// A second match is all it takes to know the lookup is ambiguous.
+	users, err := s.find(ctx, query, options.Find().SetLimit(2).SetCollation(userCollation(filter)))
+	if err != nil {
+		return nil, err
+	}
+	return onlyUser(users)
+}
+
+func (s *MongoUserStore) Find(ctx context.Context, filter supersimple.UserFilter, orderBy []*UserOrderBy) ([]*supersimple.User, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	query, err := userFilterBSON(filter)
+	if err != nil {
+		return nil, err
+	}
+	return s.find(ctx, query, options.Find().SetSort(userSortBSON(orderBy)).SetCollation(userCollation(filter)))
+}
+
+func (s *MongoUserStore) Page(ctx context.Context, filter supersimple.UserFilter, after, before *primitive.ObjectID, limit int, fromEnd bool) ([]*supersimple.User, bool, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	query, err := userFilterBSON(filter)
+	if err != nil {
+		return nil, false, err
+	}
+
+	bounds := bson.D{}
+	if after != nil {
+		bounds = append(bounds, bson.E{Key: "$gt", Value: *after})
+	}
+	if before != nil {
+		bounds = append(bounds, bson.E{Key: "$lt", Value: *before})
+	}
+
+	if len(bounds) > 0 {
+		query = bson.D{
+			{Key: "$and", Value: bson.A{query, bson.D{{Key: "_id", Value: bounds}}}},
+		}
+	}
+
+	dir := 1
+	if fromEnd {
+		dir = -1
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: dir}}).
+		SetLimit(int64(limit) + 1).
+		SetCollation(userCollation(filter))
+
+	results, err := s.find(ctx, query, opts)
+	if err != nil {
+		return nil, false, err
+	}
+
+	more := len(results) > limit
+	if more {
+		results = results[:limit]
+	}
+	if fromEnd {
+		for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+			results[i], results[j] = results[j], results[i]
+		}
+	}
+	return results, more, nil
+}
+
+func (s *MongoUserStore) UpdateName(ctx context.Context, id primitive.ObjectID, name string, expected *int) (*supersimple.User, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	update := bson.D{
+		{
+			Key: "$set", Value: bson.D{
+				{Key: "name", Value: name},
+				{Key: "updatedAt", Value: now()},
+			},
+		},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	}
+
+	opts := options.FindOneAndUpdate()
+	opts.SetReturnDocument(options.After)
+
+	var u supersimple.User
+
+	err := s.collection.FindOneAndUpdate(ctx, append(versionFilter(id, expected), notDeleted), update, opts).Decode(&u)
+	if err != nil {
+		return nil, s.writeError(ctx, id, expected, err)
+	}
+	return &u, nil
+}
+
+func (s *MongoUserStore) Replace(ctx context.Context, u *supersimple.User, upsert bool, expected *int) (bool, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	var current supersimple.User
+
+	err := s.collection.FindOne(ctx, bson.D{{Key: "_id", Value: u.ID}}).Decode(&current)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return false, err
+	}
+
+	// A soft-deleted user counts as missing, so upserting over one
+	// replaces it with a new, live user.
+	exists := err == nil && current.DeletedAt == nil
+	switch {
+	case !exists && !upsert:
+		return false, ErrNotFound
+	case !exists && expected != nil:
+		return false, ErrVersionConflict
+	case exists && expected != nil && current.Version != *expected:
+		return false, ErrVersionConflict
+	}
+
+	u.Version = current.Version + 1
+	u.UpdatedAt = now()
+	u.CreatedAt = current.CreatedAt
+	if !exists {
+		u.CreatedAt = u.UpdatedAt
+	}
+
+	if err == mongo.ErrNoDocuments {
+		if _, err := s.collection.InsertOne(ctx, *u); err != nil {
+			return false, s.insertError(ctx, u.ID, err)
+		}
+		return true, nil
+	}
+
+	// Replacing only the version just read keeps a concurrent write from
+	// being overwritten between the read and the replace.
+	err = s.collection.FindOneAndReplace(ctx, versionFilter(u.ID, &current.Version), *u).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, ErrVersionConflict
+	}
+	if err != nil {
+		return false, mongoError(err)
+	}
+	return !exists, nil
+}
+
+func (s *MongoUserStore) Delete(ctx context.Context, id primitive.ObjectID, expected *int) (*supersimple.User, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	opts := options.FindOneAndUpdate()
+	opts.SetReturnDocument(options.After)
+
+	var u supersimple.User
+
+	err := s.collection.FindOneAndUpdate(ctx, append(versionFilter(id, expected), notDeleted), softDelete(now()), opts).Decode(&u)
+	if err != nil {
+		return nil, s.writeError(ctx, id, expected, err)
+	}
+	return &u, nil
+}
+
+func (s *MongoUserStore) InsertMany(ctx context.Context, users []*supersimple.User) ([]error, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	t := now()
+	docs := make([]interface{}, len(users))
+	for i, u := range users {
+		u.ID = primitive.NewObjectID()
+		u.Version = 1
+		u.CreatedAt = t
+		u.UpdatedAt = t
+		docs[i] = *u
+	}
+
+	errs := make([]error, len(users))
+
+	_, err := s.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if bwe, ok := err.(mongo.BulkWriteException); ok && bwe.WriteConcernError == nil {
+		for _, we := range bwe.WriteErrors {
+			if we.Index >= 0 && we.Index < len(users) {
+				errs[we.Index] = mongoError(we.WriteError)
+				users[we.Index].ID = primitive.NilObjectID
+			}
+		}
+		return errs, nil
+	}
+	if err != nil {
+		for _, u := range users {
+			u.ID = primitive.NilObjectID
+		}
+		return nil, err
+	}
+	return errs, nil
+}
+
+func (s *MongoUserStore) UpdateMany(ctx context.Context, filter supersimple.UserFilter, patch supersimple.UserPatch) ([]*supersimple.User, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	query, err := userFilterBSON(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := s.ids(ctx, query, options.Find().SetCollation(userCollation(filter)))
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	// Renaming several users to one name can only fail, so fail before
+	// the first of them is written.
+	if patch.Name != nil && len(ids) > 1 {
+		return nil, ErrDuplicate
+	}
+
+	// The filter is checked again on write, so that users deleted or
+	// changed since they were read are left alone. The users written are
+	// those stamped with t.
+	t := now()
+	write := bson.D{
+		{Key: "$and", Value: bson.A{
+			bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}},
+			query,
+		}},
+		notDeleted,
+	}
+
+	update := bson.D{
+		{Key: "$set", Value: append(userPatchBSON(patch), bson.E{Key: "updatedAt", Value: t})},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	}
+
+	if _, err := s.collection.UpdateMany(ctx, write, update, options.Update().SetCollation(userCollation(filter))); err != nil {
+		return nil, mongoError(err)
+	}
+	return s.find(ctx, bson.D{
+		{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}},
+		{Key: "updatedAt", Value: t},
+	})
+}
+
+func (s *MongoUserStore) DeleteMany(ctx context.Context, ids []primitive.ObjectID) ([]*supersimple.User, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}},
+		notDeleted,
+	}
+
+	live, err := s.ids(ctx, filter)
+	if err != nil || len(live) == 0 {
+		return nil, err
+	}
+
+	// Users deleted since they were read are left alone, so that their
+	// deletedAt, and with it their purge, is not pushed back.
+	t := now()
+	write := bson.D{
+		{Key: "_id", Value: bson.D{{Key: "$in", Value: live}}},
+		notDeleted,
+	}
+
+	if _, err := s.collection.UpdateMany(ctx, write, softDelete(t)); err != nil {
+		return nil, err
+	}
+	return s.find(ctx, bson.D{
+		{Key: "_id", Value: bson.D{{Key: "$in", Value: live}}},
+		{Key: "deletedAt", Value: t},
+	})
+}
+
+func (s *MongoUserStore) Count(ctx context.Context, filter supersimple.UserFilter) (int64, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	query, err := userFilterBSON(filter)
+	if err != nil {
+		return 0, err
+	}
+	return s.collection.CountDocuments(ctx, query, options.Count().SetCollation(userCollation(filter)))
+}
+
+func (s *MongoUserStore) UpdateAll(ctx context.Context, patch supersimple.UserPatch) ([]*supersimple.User, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	t := now()
+	update := bson.D{
+		{Key: "$set", Value: append(userPatchBSON(patch), bson.E{Key: "updatedAt", Value: t})},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	}
+
+	if patch.Name != nil {
+		n, err := s.collection.CountDocuments(ctx, bson.D{notDeleted})
+		if err != nil {
+			return nil, err
+		}
+		if n > 1 {
+			return nil, ErrDuplicate
+		}
+	}
+
+	// The users written are those stamped with t.
+	if _, err := s.collection.UpdateMany(ctx, bson.D{notDeleted}, update); err != nil {
+		return nil, mongoError(err)
+	}
+	return s.find(ctx, bson.D{notDeleted, {Key: "updatedAt", Value: t}})
+}
+
+func (s *MongoUserStore) DeleteAll(ctx context.Context) ([]*supersimple.User, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	// The users written are those stamped with t.
+	t := now()
+	if _, err := s.collection.UpdateMany(ctx, bson.D{notDeleted}, softDelete(t)); err != nil {
+		return nil, err
+	}
+	return s.find(ctx, bson.D{{Key: "deletedAt", Value: t}})
+}
+
+func (s *MongoUserStore) Restore(ctx context.Context, id primitive.ObjectID) (*supersimple.User, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "_id", Value: id},
+		{Key: "deletedAt", Value: bson.D{{Key: "$ne", Value: nil}}},
+	}
+
+	update := bson.D{
+		{Key: "$unset", Value: bson.D{{Key: "deletedAt", Value: ""}}},
+		{Key: "$set", Value: bson.D{{Key: "updatedAt", Value: now()}}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	}
+
+	opts := options.FindOneAndUpdate()
+	opts.SetReturnDocument(options.After)
+
+	var u supersimple.User
+
+	err := s.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&u)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	return &u, nil
+}
+
+func (s *MongoUserStore) Purge(ctx context.Context, t time.Time) (int64, error) {
+	ctx, cancel := s.db.opContext(ctx)
+	defer cancel()
+
+	res, err := s.collection.DeleteMany(ctx, bson.D{{Key: "deletedAt", Value: bson.D{{Key: "$lt", Value: t}}}})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+// Watch feeds the hub from a change stream on the users collection until
+// ctx is done. It fails straight away if the server cannot open a change
+// stream, for example because it is not a replica set, in which case the
+// hub keeps publishing in-process.
+func (s *MongoUserStore) Watch(ctx context.Context, events *UserEvents) error {
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+
+	cs, err := s.collection.Watch(ctx, mongo.Pipeline{}, opts)
+	if err != nil {
+		return err
+	}
+	events.setStreaming(true)
+
+	go func() {
+		defer events.setStreaming(false)
+		defer cs.Close(context.Background())
+
+		for cs.Next(ctx) {
+			var change struct {
+				OperationType string            `bson:"operationType"`
+				FullDocument  *supersimple.User `bson:"fullDocument"`
+			}
+			if err := cs.Decode(&change); err != nil {
+				log.Println("Error:", err)
+				continue
+			}
+
+			switch change.OperationType {
+			case "insert":
+				events.Publish(UserEvent{Kind: UserCreated, User: change.FullDocument})
+			case "update", "replace":
+				switch {
+				case change.FullDocument == nil:
+				case change.FullDocument.DeletedAt != nil:
+					events.Publish(UserEvent{Kind: UserDeleted, User: change.FullDocument})
+				default:
+					events.Publish(UserEvent{Kind: UserUpdated, User: change.FullDocument})
+				}
+			case "delete":
+				// Only the purge removes documents, and their deletion was
+				// reported when they were soft-deleted.
+			}
+		}
+		if err := cs.Err(); err != nil && ctx.Err() == nil {
+			log.Println("users change stream stopped, publishing in-process:", err)
+		}
+	}()
+	return nil
+}
+
+// find decodes every user matching filter, in ID order unless opts
+// say otherwise.
+func (s *MongoUserStore) find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) ([]*supersimple.User, error) {
+	opts = append([]*options.FindOptions{options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})}, opts...)
+
+	cur, err := s.collection.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var results []*supersimple.User
+
+	for cur.Next(ctx) {
+		var elem supersimple.User
+		if err := cur.Decode(&elem); err != nil {
+			return nil, err
+		}
+		results = append(results, &elem)
+	}
+
+	return results, cur.Err()
+}
+
+// ids returns the IDs of every user matching filter.
+func (s *MongoUserStore) ids(ctx context.Context, filter interface{}, opts ...*options.FindOptions) ([]primitive.ObjectID, error) {
+	opts = append(opts, options.Find().SetProjection(bson.D{{Key: "_id", Value: 1}}))
+
+	cur, err := s.collection.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var ids []primitive.ObjectID
+
+	for cur.Next(ctx) {
+		var elem struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cur.Decode(&elem); err != nil {
+			return nil, err
+		}
+		ids = append(ids, elem.ID)
+	}
+
+	return ids, cur.Err()
+}
+
+func userPatchBSON(p supersimple.UserPatch) bson.D {
+	set := bson.D{}
+	if p.Name != nil {
+		set = append(set, bson.E{Key: "name", Value: *p.Name})
+	}
+	return set
+}
+
+// softDelete is the update that soft-deletes users at t.
+func softDelete(t time.Time) bson.D {
+	return bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "deletedAt", Value: t},
+			{Key: "updatedAt", Value: t},
+		}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	}
+}
+
+// versionFilter matches the user with the given id and, if expected is
+// non-nil, that version. Users stored before versioning have no version
+// field, which decodes as 0.
+func versionFilter(id primitive.ObjectID, expected *int) bson.D {
+	filter := bson.D{
+		{Key: "_id", Value: id},
+	}
+	switch {
+	case expected == nil:
+	case *expected == 0:
+		filter = append(filter, bson.E{Key: "version", Value: bson.D{{Key: "$in", Value: bson.A{0, nil}}}})
+	default:
+		filter = append(filter, bson.E{Key: "version", Value: *expected})
+	}
+	return filter
+}
+
+// writeError explains why a write filtered by versionFilter matched
+// nothing: either the user does not exist or its version moved on.
+func (s *MongoUserStore) writeError(ctx context.Context, id primitive.ObjectID, expected *int, err error) error {
+	if err != mongo.ErrNoDocuments || expected == nil {
+		return mongoError(err)
+	}
+
+	n, err := s.collection.CountDocuments(ctx, bson.D{{Key: "_id", Value: id}, notDeleted})
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrVersionConflict
+	}
+	return ErrNotFound
+}
+
+// insertError explains why inserting the user with the given id failed.
+// A duplicate _id means someone else created the user in the meantime.
+func (s *MongoUserStore) insertError(ctx context.Context, id primitive.ObjectID, err error) error {
+	if !isDuplicateKey(err) {
+		return err
+	}
+
+	n, err := s.collection.CountDocuments(ctx, bson.D{{Key: "_id", Value: id}})
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrVersionConflict
+	}
+	return ErrDuplicate
+}
+
+// duplicateKey is the server error code for a unique index violation.
+const duplicateKey = 11000
+
+// isDuplicateKey reports whether err is a unique index violation.
+func isDuplicateKey(err error) bool {
+	switch e := err.(type) {
+	case mongo.WriteException:
+		for _, we := range e.WriteErrors {
+			if we.Code == duplicateKey {
+				return true
+			}
+		}
+	case mongo.BulkWriteException:
+		for _, we := range e.WriteErrors {
+			if we.Code == duplicateKey {
+				return true
+			}
+		}
+	case mongo.WriteError:
+		return e.Code == duplicateKey
+	case mongo.CommandError:
+		return e.Code == duplicateKey
+	}
+	return false
+}
+
+// mongoError translates driver errors into the store's error values.
+func mongoError(err error) error {
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
+	if isDuplicateKey(err) {
+		return ErrDuplicate
+	}
+	return err
+}